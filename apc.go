@@ -0,0 +1,106 @@
+package terminal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// APCHandler handles the payload of an Application Program Command sequence
+// ("ESC _ namespace;payload BEL") in a namespace it was registered for. The
+// returned data is merged into the metadata of the current line under the
+// namespace, where it can be read with Screen.LineMetadata or rendered with
+// WithMetadataAttributes. Returning nil data adds no metadata. If an error is
+// returned, its message is written to the screen.
+type APCHandler = func(payload string) (map[string]string, error)
+
+// KeyValueAPCHandler is an APCHandler that parses the payload as
+// semicolon-separated key=value pairs, the same as Buildkite APCs
+// (e.g. "ESC _ ourtool;phase=build;retry=2 BEL"). Values containing
+// semicolons can be quoted, or the semicolons escaped with a backslash.
+func KeyValueAPCHandler(payload string) (map[string]string, error) {
+	pairs, err := parseKeyValues(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		data[pair[0]] = pair[1]
+	}
+	return data, nil
+}
+
+// parseKeyValues parses semicolon-separated key=value pairs, in order.
+func parseKeyValues(payload string) ([][2]string, error) {
+	tokens, err := tokenizeString(payload, ';', '\\')
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := make([][2]string, 0, len(tokens))
+	for _, token := range tokens {
+		key, val, ok := strings.Cut(token, "=")
+		if !ok {
+			return nil, fmt.Errorf("Failed to read key=value from token %q", token)
+		}
+		pairs = append(pairs, [2]string{key, val})
+	}
+	return pairs, nil
+}
+
+// isAPCNamespace reports whether ns can be used as an APC namespace. Since
+// namespaces appear in attribute names (data-<namespace>-<key>), they have
+// the same restrictions as metadata keys.
+func isAPCNamespace(ns string) bool {
+	return isMetadataAttributeKey(ns)
+}
+
+// RegisterAPCHandler registers fn to handle APC sequences in the namespace,
+// replacing any handler previously registered for it. APC sequences in
+// namespaces without a handler are ignored. The "bk" namespace is handled by
+// the screen itself, and can't be registered. A handler for the "G" namespace
+// receives "ESC _ G;payload" sequences, which would otherwise be handled as
+// Kitty graphics.
+func (s *Screen) RegisterAPCHandler(namespace string, fn APCHandler) error {
+	if namespace == bkNamespace {
+		return fmt.Errorf("APC namespace %q is reserved", namespace)
+	}
+	if !isAPCNamespace(namespace) {
+		return fmt.Errorf("invalid APC namespace %q", namespace)
+	}
+	if fn == nil {
+		return fmt.Errorf("nil handler for APC namespace %q", namespace)
+	}
+	if s.apcHandlers == nil {
+		s.apcHandlers = make(map[string]APCHandler)
+	}
+	s.apcHandlers[namespace] = fn
+	return nil
+}
+
+// WithAPCHandler registers fn to handle APC sequences in the namespace (see
+// Screen.RegisterAPCHandler).
+func WithAPCHandler(namespace string, fn APCHandler) ScreenOption {
+	return func(s *Screen) error { return s.RegisterAPCHandler(namespace, fn) }
+}
+
+// handleRegisteredAPC passes an APC sequence to the handler registered for
+// its namespace, if any.
+func (p *parser) handleRegisteredAPC(sequence string) {
+	namespace, payload, _ := strings.Cut(sequence, ";")
+	fn := p.screen.apcHandlers[namespace]
+	if fn == nil {
+		return
+	}
+
+	data, err := fn(payload)
+	if err != nil {
+		p.screen.appendMany([]rune(fmt.Sprintf("*** Error handling %s APC ANSI escape sequence: ", namespace)))
+		p.screen.appendMany([]rune(err.Error()))
+		return
+	}
+	if data == nil {
+		return
+	}
+	p.screen.setLineMetadata(namespace, data)
+}
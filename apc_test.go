@@ -0,0 +1,130 @@
+package terminal
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestKeyValueAPCHandler(t *testing.T) {
+	t.Parallel()
+
+	got, err := KeyValueAPCHandler(`phase=build;name='a;b';x=1;x=2`)
+	if err != nil {
+		t.Fatalf("KeyValueAPCHandler error = %v", err)
+	}
+	want := map[string]string{"phase": "build", "name": "a;b", "x": "2"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("KeyValueAPCHandler diff (-got +want):\n%s", diff)
+	}
+
+	if _, err := KeyValueAPCHandler("novalue"); err == nil {
+		t.Errorf("KeyValueAPCHandler(%q) error = nil, want error", "novalue")
+	}
+}
+
+func TestRegisteredAPCHandler(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		opts     []ScreenOption
+		input    string
+		wantHTML string
+		wantMD   map[string]map[string]string
+	}{
+		{
+			name:     "unregistered namespace is ignored",
+			input:    "\x1b_ourtool;phase=build\x07hello",
+			wantHTML: "hello",
+		},
+		{
+			name:     "key=value handler keeps metadata",
+			opts:     []ScreenOption{WithAPCHandler("ourtool", KeyValueAPCHandler)},
+			input:    "\x1b_ourtool;phase=build\x07hel\x1b_ourtool;retry=1\x07lo",
+			wantHTML: "hello",
+			wantMD:   map[string]map[string]string{"ourtool": {"phase": "build", "retry": "1"}},
+		},
+		{
+			name: "rendered as attributes",
+			opts: []ScreenOption{
+				WithAPCHandler("ourtool", KeyValueAPCHandler),
+				WithMetadataAttributes("phase", "ourtool.*"),
+			},
+			input:    "\x1b_ourtool;retry=1\x07\x1b_bk;phase=test;plugin=docker\x07hello",
+			wantHTML: `<span class="term-line" data-bk-phase="test" data-ourtool-retry="1">hello</span>`,
+			wantMD: map[string]map[string]string{
+				"bk":      {"phase": "test", "plugin": "docker"},
+				"ourtool": {"retry": "1"},
+			},
+		},
+		{
+			name: "custom handler",
+			opts: []ScreenOption{WithAPCHandler("marker", func(payload string) (map[string]string, error) {
+				return map[string]string{"label": payload}, nil
+			})},
+			input:    "\x1b_marker;deploy started\x1b\\hello",
+			wantHTML: "hello",
+			wantMD:   map[string]map[string]string{"marker": {"label": "deploy started"}},
+		},
+		{
+			name:     "G handler takes precedence over Kitty graphics",
+			opts:     []ScreenOption{WithAPCHandler("G", KeyValueAPCHandler)},
+			input:    "\x1b_G;a=T\x07hello",
+			wantHTML: "hello",
+			wantMD:   map[string]map[string]string{"G": {"a": "T"}},
+		},
+		{
+			name: "handler returning nil adds nothing",
+			opts: []ScreenOption{WithAPCHandler("noop", func(string) (map[string]string, error) {
+				return nil, nil
+			})},
+			input:    "\x1b_noop;x\x07hello",
+			wantHTML: "hello",
+		},
+		{
+			name: "handler error is shown",
+			opts: []ScreenOption{WithAPCHandler("bad", func(string) (map[string]string, error) {
+				return nil, errors.New("oops")
+			})},
+			input:    "\x1b_bad;x\x07hello",
+			wantHTML: "*** Error handling bad APC ANSI escape sequence: oopshello",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := NewScreen(test.opts...)
+			if err != nil {
+				t.Fatalf("NewScreen(...) error = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if diff := cmp.Diff(s.AsHTML(), test.wantHTML); diff != "" {
+				t.Errorf("AsHTML() diff (-got +want):\n%s", diff)
+			}
+			if diff := cmp.Diff(s.LineMetadata(0), test.wantMD); diff != "" {
+				t.Errorf("LineMetadata(0) diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRegisterAPCHandlerErrors(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() error = %v", err)
+	}
+	for _, ns := range []string{"bk", "", "our tool", "a;b"} {
+		if err := s.RegisterAPCHandler(ns, KeyValueAPCHandler); err == nil {
+			t.Errorf("RegisterAPCHandler(%q, KeyValueAPCHandler) error = nil, want error", ns)
+		}
+	}
+	if err := s.RegisterAPCHandler("ourtool", nil); err == nil {
+		t.Errorf("RegisterAPCHandler(%q, nil) error = nil, want error", "ourtool")
+	}
+}
@@ -0,0 +1,133 @@
+package terminal
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// AssetSink stores inline images outside the HTML output (see
+// WithAssetSink).
+type AssetSink interface {
+	// Put stores an image, and returns the URL to use for it.
+	Put(contentType string, content []byte) (url string, err error)
+}
+
+// WithAssetSink stores inline images in sink, and refers to them by URL
+// instead of embedding them in the output. Images are still subject to the
+// image policy (see WithImagePolicy).
+func WithAssetSink(sink AssetSink) ScreenOption {
+	return func(s *Screen) error {
+		s.assetSink = sink
+		return nil
+	}
+}
+
+// FileAssetSink is an AssetSink that writes each image to a file in a
+// directory, named by the SHA-256 hash of its content, so identical images
+// are only stored once. It's safe for concurrent use.
+type FileAssetSink struct {
+	dir, urlPrefix string
+}
+
+// NewFileAssetSink returns a FileAssetSink that writes images to dir, and
+// refers to them by urlPrefix followed by the file name (e.g. with urlPrefix
+// "https://example.com/assets/"). dir is created if needed.
+func NewFileAssetSink(dir, urlPrefix string) *FileAssetSink {
+	return &FileAssetSink{dir: dir, urlPrefix: urlPrefix}
+}
+
+// Put writes the image to the directory, unless it's already there.
+func (f *FileAssetSink) Put(contentType string, content []byte) (string, error) {
+	sum := sha256.Sum256(content)
+	name := hex.EncodeToString(sum[:]) + assetExtension(contentType)
+	url := f.urlPrefix + name
+
+	path := filepath.Join(f.dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return url, nil
+	}
+	if err := os.MkdirAll(f.dir, 0o755); err != nil {
+		return "", fmt.Errorf("create asset directory: %w", err)
+	}
+
+	// Write to a temporary file and rename it into place, so that a partly
+	// written file is never served, and concurrent writers don't conflict.
+	tmp, err := os.CreateTemp(f.dir, ".asset-*")
+	if err != nil {
+		return "", fmt.Errorf("create asset file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("write asset file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("write asset file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return "", fmt.Errorf("write asset file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("write asset file: %w", err)
+	}
+	return url, nil
+}
+
+// Preferred extensions for common image types, where mime.ExtensionsByType
+// returns several.
+var assetExtensions = map[string]string{
+	"image/gif":  ".gif",
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// assetExtension returns the file name extension for a content type.
+func assetExtension(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	if ext, ok := assetExtensions[mt]; ok {
+		return ext
+	}
+	exts, err := mime.ExtensionsByType(mt)
+	if err != nil || len(exts) == 0 {
+		return ""
+	}
+	return slices.Min(exts)
+}
+
+// assetURL stores an inline image in the screen's asset sink, and returns its
+// URL. The URL (or error) is kept with the element, so rendering it again
+// doesn't store it again. Errors are passed to AssetErrorFunc, rather than
+// shown in the output, since they can include details such as file paths.
+func (s *Screen) assetURL(i *element) (string, error) {
+	if i.assetURL != "" || i.assetErr != nil {
+		return i.assetURL, i.assetErr
+	}
+	url, err := s.storeAsset(i)
+	if err != nil {
+		i.assetErr = err
+		if s.AssetErrorFunc != nil {
+			s.AssetErrorFunc(err)
+		}
+		return "", err
+	}
+	i.assetURL = url
+	return url, nil
+}
+
+// storeAsset decodes an inline image and puts it in the asset sink.
+func (s *Screen) storeAsset(i *element) (string, error) {
+	content, err := base64.StdEncoding.DecodeString(i.content)
+	if err != nil {
+		return "", err
+	}
+	return s.assetSink.Put(i.contentType, content)
+}
@@ -0,0 +1,83 @@
+package terminal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFileAssetSink(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "assets")
+	sink := NewFileAssetSink(dir, "https://example.org/assets/")
+
+	// "AA==" is a single zero byte.
+	const name = "6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d.png"
+	s, err := NewScreen(WithAssetSink(sink))
+	if err != nil {
+		t.Fatalf("NewScreen(WithAssetSink(...)) error = %v", err)
+	}
+	s.Write([]byte("\x1b]1337;File=name=YS5wbmc=;inline=1:AA==\x07\x1b]1337;File=name=Yi5wbmc=;inline=1:AA==\x07"))
+	want := `<img alt="a.png" src="https://example.org/assets/` + name + `">` + "\n" +
+		`<img alt="b.png" src="https://example.org/assets/` + name + `">`
+	if diff := cmp.Diff(s.AsHTML(), want); diff != "" {
+		t.Errorf("AsHTML() diff (-got +want):\n%s", diff)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("os.ReadDir(%q) error = %v", dir, err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if diff := cmp.Diff(names, []string{name}); diff != "" {
+		t.Errorf("asset directory diff (-got +want):\n%s", diff)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error = %v", name, err)
+	}
+	if want := []byte{0}; !cmp.Equal(got, want) {
+		t.Errorf("asset contents = %v, want %v", got, want)
+	}
+}
+
+type errAssetSink struct{}
+
+func (errAssetSink) Put(string, []byte) (string, error) { return "", errors.New("disk full") }
+
+func TestAssetSinkError(t *testing.T) {
+	s, err := NewScreen(WithAssetSink(errAssetSink{}))
+	if err != nil {
+		t.Fatalf("NewScreen(WithAssetSink(...)) error = %v", err)
+	}
+	var errs []string
+	s.AssetErrorFunc = func(err error) { errs = append(errs, err.Error()) }
+	s.Write([]byte("\x1b]1337;File=name=YS5wbmc=;inline=1:AA==\x07"))
+	want := `<span class="term-image-placeholder">[image a.png: couldn&#39;t store image]</span>`
+	for range 2 {
+		if diff := cmp.Diff(s.AsHTML(), want); diff != "" {
+			t.Errorf("AsHTML() diff (-got +want):\n%s", diff)
+		}
+	}
+	if diff := cmp.Diff(errs, []string{"disk full"}); diff != "" {
+		t.Errorf("AssetErrorFunc errors diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestAssetExtension(t *testing.T) {
+	for ct, want := range map[string]string{
+		"image/png":     ".png",
+		"image/jpeg":    ".jpg",
+		"image/svg+xml": ".svg",
+		"nonsense":      "",
+	} {
+		if got := assetExtension(ct); got != want {
+			t.Errorf("assetExtension(%q) = %q, want %q", ct, got, want)
+		}
+	}
+}
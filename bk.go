@@ -2,8 +2,11 @@ package terminal
 
 import (
 	"fmt"
+	"maps"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const bkNamespace = "bk"
@@ -15,20 +18,15 @@ func (p *parser) parseBuildkiteAPC(sequence string) (map[string]string, error) {
 		return nil, nil
 	}
 
-	tokens, err := tokenizeString(sequence[3:], ';', '\\')
+	pairs, err := parseKeyValues(sequence[len(bkNamespace)+1:])
 	if err != nil {
 		return nil, err
 	}
 
 	data := map[string]string{}
 
-	for _, token := range tokens {
-		tokenParts := strings.SplitN(token, "=", 2)
-		if len(tokenParts) != 2 {
-			return nil, fmt.Errorf("Failed to read key=value from token %q", token)
-		}
-
-		key, val := tokenParts[0], tokenParts[1]
+	for _, pair := range pairs {
+		key, val := pair[0], pair[1]
 		switch key {
 		case "t":
 			t, err := strconv.ParseInt(val, 10, 64)
@@ -52,5 +50,131 @@ func (p *parser) parseBuildkiteAPC(sequence string) (map[string]string, error) {
 		}
 	}
 
+	if _, ok := data["t"]; ok && !p.hasFirstTimestamp {
+		p.firstTimestamp, p.hasFirstTimestamp = p.lastTimestamp, true
+	}
+
 	return data, nil
 }
+
+// lineMetadata combines the metadata in a namespace for all parts of a line.
+// When parts have the same key, the last one wins.
+func lineMetadata(parts []screenLine, namespace string) map[string]string {
+	md := make(map[string]string)
+	for _, l := range parts {
+		maps.Copy(md, l.metadata[namespace])
+	}
+	return md
+}
+
+// isMetadataAttributeKey reports whether key can be used in a data-bk-<key>
+// attribute name.
+func isMetadataAttributeKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// splitMetadataAttribute splits a key passed to WithMetadataAttributes into
+// its namespace and key.
+func splitMetadataAttribute(sel string) (namespace, key string) {
+	if ns, key, ok := strings.Cut(sel, "."); ok {
+		return ns, key
+	}
+	return bkNamespace, sel
+}
+
+// metadataAttributeSelected reports whether the metadata key in the namespace
+// should be rendered as an attribute, given the keys passed to
+// WithMetadataAttributes. The Buildkite timestamp is rendered separately, so
+// is never selected by "*".
+func metadataAttributeSelected(sels []string, namespace, key string) bool {
+	if !isMetadataAttributeKey(key) {
+		return false
+	}
+	for _, sel := range sels {
+		ns, k := splitMetadataAttribute(sel)
+		if !strings.EqualFold(ns, namespace) {
+			continue
+		}
+		if strings.EqualFold(k, key) || (k == "*" && !(namespace == bkNamespace && key == "t")) {
+			return true
+		}
+	}
+	return false
+}
+
+// lineNamespaces returns the metadata namespaces used by any part of a line,
+// in order.
+func lineNamespaces(parts []screenLine) []string {
+	var namespaces []string
+	for _, l := range parts {
+		for ns := range l.metadata {
+			if !slices.Contains(namespaces, ns) {
+				namespaces = append(namespaces, ns)
+			}
+		}
+	}
+	slices.Sort(namespaces)
+	return namespaces
+}
+
+// lineTimestamp returns the Buildkite timestamp (milliseconds since the
+// epoch) for a line, if it has a well-formed one.
+func lineTimestamp(parts []screenLine) (int64, bool) {
+	// Only the last timestamp counts, so look backwards.
+	for i := len(parts) - 1; i >= 0; i-- {
+		t, ok := parts[i].metadata[bkNamespace]["t"]
+		if !ok {
+			continue
+		}
+		millis, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return millis, true
+	}
+	return 0, false
+}
+
+// millisToTime converts a Buildkite timestamp to a UTC time.
+func millisToTime(millis int64) time.Time {
+	return time.Unix(millis/1000, (millis%1000)*1_000_000).UTC()
+}
+
+// formatTimestamp formats a Buildkite timestamp as visible text, according to
+// the screen's timestamp options. defaultLayout is used if no layout has been
+// set with WithTimestampLayout.
+func (s *Screen) formatTimestamp(millis int64, defaultLayout string) string {
+	if s.relativeTimestamps {
+		return formatRelativeTimestamp(millis - s.parser.firstTimestamp)
+	}
+	t := millisToTime(millis)
+	if s.timestampLocation != nil {
+		t = t.In(s.timestampLocation)
+	}
+	layout := s.timestampLayout
+	if layout == "" {
+		layout = defaultLayout
+	}
+	return t.Format(layout)
+}
+
+// formatRelativeTimestamp formats a duration in milliseconds as
+// +hh:mm:ss.mmm (or -hh:mm:ss.mmm if negative).
+func formatRelativeTimestamp(millis int64) string {
+	sign := "+"
+	if millis < 0 {
+		sign, millis = "-", -millis
+	}
+	return fmt.Sprintf("%s%02d:%02d:%02d.%03d", sign,
+		millis/3_600_000, millis/60_000%60, millis/1000%60, millis%1000)
+}
@@ -30,6 +30,13 @@ func TestParseBuildkiteAPC(t *testing.T) {
 			wantData:   map[string]string{"t": "12468"},
 			wantLastTS: 12468,
 		},
+		{
+			name:       "dt after t in the same sequence",
+			parser:     &parser{},
+			sequence:   "bk;t=12345;dt=5",
+			wantData:   map[string]string{"t": "12350"},
+			wantLastTS: 12350,
+		},
 		{
 			name:       "other field parsed",
 			parser:     &parser{},
@@ -57,3 +64,99 @@ func TestParseBuildkiteAPC(t *testing.T) {
 
 	}
 }
+
+func TestMetadataAttributes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		keys  []string
+		input string
+		want  string
+	}{
+		{
+			name:  "no keys selected",
+			input: "\x1b_bk;phase=build\x07hello",
+			want:  "hello",
+		},
+		{
+			name:  "selected keys",
+			keys:  []string{"phase", "retry"},
+			input: "\x1b_bk;retry=2;phase=build;plugin=docker\x07hello\nworld",
+			want:  `<span class="term-line" data-bk-phase="build" data-bk-retry="2">hello</span>` + "\nworld",
+		},
+		{
+			name:  "all keys except timestamp",
+			keys:  []string{"*"},
+			input: "\x1b_bk;t=1000;Plugin=docker\x07hello",
+			want:  `<span class="term-line" data-bk-plugin="docker"><time datetime="1970-01-01T00:00:01Z">1970-01-01T00:00:01Z</time>hello</span>`,
+		},
+		{
+			name:  "values are escaped and bad keys skipped",
+			keys:  []string{"*"},
+			input: "\x1b_bk;phase=<b>&;bad key=x\x07hello",
+			want:  `<span class="term-line" data-bk-phase="&lt;b&gt;&amp;">hello</span>`,
+		},
+		{
+			name:  "empty line",
+			keys:  []string{"phase"},
+			input: "\x1b_bk;phase=build\x07",
+			want:  `<span class="term-line" data-bk-phase="build">&nbsp;</span>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := NewScreen(WithMetadataAttributes(test.keys...))
+			if err != nil {
+				t.Fatalf("NewScreen(WithMetadataAttributes(%q...)) error = %v", test.keys, err)
+			}
+			s.Write([]byte(test.input))
+			if diff := cmp.Diff(s.AsHTML(), test.want); diff != "" {
+				t.Errorf("AsHTML() diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMetadataAttributesInvalidKey(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewScreen(WithMetadataAttributes("data bk")); err == nil {
+		t.Errorf("NewScreen(WithMetadataAttributes(%q)) error = nil, want error", "data bk")
+	}
+}
+
+func TestLineMetadata(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewScreen(WithMaxSize(0, 2))
+	if err != nil {
+		t.Fatalf("NewScreen(WithMaxSize(0, 2)) error = %v", err)
+	}
+	s.Write([]byte("\x1b_bk;phase=setup\x07one\ntwo\n\x1b_bk;t=1000;phase=build\x07three"))
+
+	tests := []struct {
+		index int
+		want  map[string]map[string]string
+	}{
+		{index: 0, want: nil}, // scrolled out
+		{index: 1, want: nil}, // no metadata
+		{index: 2, want: map[string]map[string]string{"bk": {"t": "1000", "phase": "build"}}},
+		{index: 3, want: nil}, // not written yet
+	}
+	for _, test := range tests {
+		got := s.LineMetadata(test.index)
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf("LineMetadata(%d) diff (-got +want):\n%s", test.index, diff)
+		}
+	}
+
+	// The result is a copy.
+	s.LineMetadata(2)["bk"]["phase"] = "changed"
+	if got, want := s.LineMetadata(2)["bk"]["phase"], "build"; got != want {
+		t.Errorf("after modifying result, LineMetadata(2)[bk][phase] = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,123 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Buildkite block, callout, artifact and badge elements are OSC sequences
+// with the same key=value arguments as 1338 images and 1339 links:
+//
+//	1340;title=…;content=…;open=1        a collapsible block
+//	1341;style=warning;title=…;content=… a callout box
+//	1342;path=…;content=…                a link to a build artifact
+//	1343;style=info;content=…            an inline badge
+//
+// Blocks and callouts appear on their own line, while artifacts and badges
+// appear inline.
+
+// elementStyles are the styles a callout or badge can have.
+var elementStyles = []string{"info", "success", "warning", "error"}
+
+// WithArtifactURL sets the URL template for artifact elements (1342). The
+// artifact's path, escaped, replaces {path} in the template, e.g.
+// "https://example.com/artifacts/{path}". The resulting URL is still checked
+// against the URL policy. Without a template, artifacts aren't linked.
+func WithArtifactURL(template string) ScreenOption {
+	return func(s *Screen) error {
+		if !strings.Contains(template, "{path}") {
+			return fmt.Errorf("artifact URL template %q doesn't contain {path}", template)
+		}
+		if _, err := url.Parse(strings.ReplaceAll(template, "{path}", "x")); err != nil {
+			return fmt.Errorf("invalid artifact URL template %q: %w", template, err)
+		}
+		s.artifactURL = template
+		return nil
+	}
+}
+
+// validateBuildkite checks the arguments of a block, callout, artifact or
+// badge element.
+func (i *element) validateBuildkite() error {
+	switch i.elementType {
+	case elementBlock:
+		if i.title == "" {
+			return errors.New("title= argument not supplied")
+		}
+	case elementCallout, elementBadge:
+		if i.content == "" {
+			return errors.New("content= argument not supplied")
+		}
+		if i.style != "" && !slices.Contains(elementStyles, i.style) {
+			return fmt.Errorf("style= value of %q is not one of %s", i.style, strings.Join(elementStyles, ", "))
+		}
+	case elementArtifact:
+		if i.path == "" {
+			return errors.New("path= argument not supplied")
+		}
+		if strings.HasPrefix(i.path, "/") {
+			// This includes //host/path, which would replace the host of
+			// the artifact URL.
+			return fmt.Errorf("path= value of %q must be relative", i.path)
+		}
+		if slices.Contains(strings.Split(i.path, "/"), "..") {
+			return fmt.Errorf("path= value of %q must not contain .. segments", i.path)
+		}
+	}
+	return nil
+}
+
+// buildkiteHTML renders a block, callout, artifact or badge element.
+func (i *element) buildkiteHTML(s *Screen) string {
+	h := html.EscapeString
+
+	switch i.elementType {
+	case elementBlock:
+		open := ""
+		if i.open {
+			open = " open"
+		}
+		return `<details class="term-block"` + open + `><summary>` + h(i.title) + `</summary>` +
+			`<div class="term-block-content">` + h(i.content) + `</div></details>`
+
+	case elementCallout:
+		style := i.style
+		if style == "" {
+			style = "info"
+		}
+		title := ""
+		if i.title != "" {
+			title = `<strong class="term-callout-title">` + h(i.title) + `</strong> `
+		}
+		return `<div class="term-callout term-callout-` + style + `">` + title + h(i.content) + `</div>`
+
+	case elementArtifact:
+		content := i.content
+		if content == "" {
+			content = i.path
+		}
+		if s.artifactURL == "" {
+			return `<span class="term-artifact">` + h(content) + `</span>`
+		}
+		path := (&url.URL{Path: i.path}).EscapedPath()
+		var buf outputBuffer
+		buf.WriteString(`<span class="term-artifact">`)
+		buf.appendAnchor(s, strings.ReplaceAll(s.artifactURL, "{path}", path), "")
+		buf.WriteString(h(content))
+		buf.closeAnchor()
+		buf.WriteString(`</span>`)
+		return buf.String()
+
+	case elementBadge:
+		class := "term-badge"
+		if i.style != "" {
+			class += " term-badge-" + i.style
+		}
+		return `<span class="` + class + `">` + h(i.content) + `</span>`
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+package terminal
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBuildkiteElementHTML(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []ScreenOption
+		element element
+		want    string
+	}{
+		{
+			name:    "collapsed block",
+			element: element{elementType: elementBlock, title: "Build output", content: "3 warnings"},
+			want:    `<details class="term-block"><summary>Build output</summary><div class="term-block-content">3 warnings</div></details>`,
+		},
+		{
+			name:    "expanded block (HTML minefield)",
+			element: element{elementType: elementBlock, title: "<b>", content: "<script>'&'</script>", open: true},
+			want:    `<details class="term-block" open><summary>&lt;b&gt;</summary><div class="term-block-content">&lt;script&gt;&#39;&amp;&#39;&lt;/script&gt;</div></details>`,
+		},
+		{
+			name:    "callout defaults to info",
+			element: element{elementType: elementCallout, content: "Deploying"},
+			want:    `<div class="term-callout term-callout-info">Deploying</div>`,
+		},
+		{
+			name:    "callout with style and title",
+			element: element{elementType: elementCallout, style: "error", title: "<Failed>", content: "exit 1 & more"},
+			want:    `<div class="term-callout term-callout-error"><strong class="term-callout-title">&lt;Failed&gt;</strong> exit 1 &amp; more</div>`,
+		},
+		{
+			name:    "artifact without a URL template",
+			element: element{elementType: elementArtifact, path: "logs/<test>.txt"},
+			want:    `<span class="term-artifact">logs/&lt;test&gt;.txt</span>`,
+		},
+		{
+			name:    "artifact with a URL template",
+			opts:    []ScreenOption{WithArtifactURL("https://example.com/artifacts/{path}")},
+			element: element{elementType: elementArtifact, path: "coverage/a b?.html", content: "Coverage"},
+			want:    `<span class="term-artifact"><a href="https://example.com/artifacts/coverage/a%20b%3F.html">Coverage</a></span>`,
+		},
+		{
+			name: "artifact URL checked against the URL policy",
+			opts: []ScreenOption{
+				WithArtifactURL("https://example.com/artifacts/{path}"),
+				WithURLPolicy(URLPolicy{AllowedHosts: []string{"example.org"}}),
+			},
+			element: element{elementType: elementArtifact, path: "a.txt"},
+			want:    `<span class="term-artifact"><a href="#">a.txt</a></span>`,
+		},
+		{
+			name:    "badge",
+			element: element{elementType: elementBadge, content: "v1.2"},
+			want:    `<span class="term-badge">v1.2</span>`,
+		},
+		{
+			name:    "badge with style",
+			element: element{elementType: elementBadge, style: "success", content: "<passed>"},
+			want:    `<span class="term-badge term-badge-success">&lt;passed&gt;</span>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(test.opts...)
+			if err != nil {
+				t.Fatalf("NewScreen() error = %v", err)
+			}
+			if diff := cmp.Diff(test.element.asHTML(s), test.want); diff != "" {
+				t.Errorf("asHTML() diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithArtifactURLErrors(t *testing.T) {
+	for _, template := range []string{
+		"https://example.com/artifacts/",
+		"https://exa mple.com/{path}",
+	} {
+		if _, err := NewScreen(WithArtifactURL(template)); err == nil {
+			t.Errorf("NewScreen(WithArtifactURL(%q)) error = nil, want an error", template)
+		}
+	}
+}
+
+func TestBuildkiteElementLines(t *testing.T) {
+	input := "before\x1b]1341;style=warning;content=careful\x07after " +
+		"\x1b]1343;style=info;content=new\x07 and \x1b]1342;path=a.txt\x07\n"
+	want := "before\n" +
+		`<div class="term-callout term-callout-warning">careful</div>` + "\n" +
+		`after <span class="term-badge term-badge-info">new</span> and <span class="term-artifact">a.txt</span>`
+
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() error = %v", err)
+	}
+	if _, err := s.Write([]byte(input)); err != nil {
+		t.Fatalf("s.Write(%q) error = %v", input, err)
+	}
+	if diff := cmp.Diff(s.AsHTML(), want); diff != "" {
+		t.Errorf("AsHTML() diff (-got +want):\n%s", diff)
+	}
+}
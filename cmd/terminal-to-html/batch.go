@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/buildkite/terminal-to-html/v3"
+)
+
+// batchConfig holds the settings for converting many files at once.
+type batchConfig struct {
+	// Directory to write output files into. Output paths mirror input paths.
+	outputDir string
+
+	// Filename pattern that files found by walking directories must match.
+	include string
+
+	// Number of files to convert concurrently.
+	jobs int
+
+	preview    bool
+	format     string
+	timestamps bool
+}
+
+// batchJob is a single input file and the output file to render it into.
+type batchJob struct {
+	src, dst string
+}
+
+// batchFailure records a file that could not be converted.
+type batchFailure struct {
+	src string
+	err error
+}
+
+// hasGlobMeta reports whether pattern contains any of the special characters
+// recognised by filepath.Match.
+func hasGlobMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
+
+// outputPath returns the path under outDir that rel should be rendered to.
+// Paths that are absolute or escape the current directory (with "..") can't
+// be mirrored, so only the base name is kept.
+func outputPath(outDir, rel, format string) string {
+	rel = filepath.Clean(rel)
+	if !filepath.IsLocal(rel) {
+		rel = filepath.Base(rel)
+	}
+	ext := ".html"
+	switch format {
+	case "plain":
+		ext = ".txt"
+	case "json":
+		ext = ".json"
+	}
+	return filepath.Join(outDir, rel+ext)
+}
+
+// batchJobs expands args (files, globs, and directories) into the list of
+// files to convert.
+func batchJobs(args []string, cfg batchConfig) ([]batchJob, error) {
+	var jobs []batchJob
+	seen := make(map[string]bool)
+	dsts := make(map[string]string) // dst -> src
+	add := func(src, rel string) error {
+		if seen[src] {
+			return nil
+		}
+		seen[src] = true
+		dst := outputPath(cfg.outputDir, rel, cfg.format)
+		if other, clash := dsts[dst]; clash {
+			return fmt.Errorf("both %s and %s would be written to %s", other, src, dst)
+		}
+		dsts[dst] = src
+		jobs = append(jobs, batchJob{src: src, dst: dst})
+		return nil
+	}
+
+	for _, arg := range args {
+		paths := []string{arg}
+		if hasGlobMeta(arg) {
+			matches, err := filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf("bad pattern %q: %w", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %q", arg)
+			}
+			paths = matches
+		}
+
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				if err := add(path, path); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			// Walk the directory tree, mirroring paths relative to its root.
+			err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if !d.Type().IsRegular() {
+					return nil
+				}
+				if ok, _ := filepath.Match(cfg.include, d.Name()); !ok {
+					return nil
+				}
+				rel, err := filepath.Rel(path, p)
+				if err != nil {
+					return err
+				}
+				return add(p, rel)
+			})
+			if err != nil {
+				return nil, fmt.Errorf("walk %s: %w", path, err)
+			}
+		}
+	}
+	return jobs, nil
+}
+
+// convertFile renders one file from job.src into job.dst using a new screen.
+func convertFile(job batchJob, cfg batchConfig, newScreen func() (*terminal.Screen, error)) (err error) {
+	screen, err := newScreen()
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(job.src)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(job.dst), 0o777); err != nil {
+		return err
+	}
+	dst, err := os.Create(job.dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			// Don't leave partial output lying around.
+			os.Remove(job.dst)
+		}
+	}()
+
+	_, _, err = process(dst, src, cfg.preview, cfg.format, cfg.timestamps, screen)
+	return err
+}
+
+// batch converts every input described by args into cfg.outputDir, using a
+// pool of cfg.jobs workers. A summary is logged to stderr, and an error is
+// returned if any file failed.
+func batch(args []string, cfg batchConfig, newScreen func() (*terminal.Screen, error)) error {
+	if _, err := filepath.Match(cfg.include, ""); err != nil {
+		return fmt.Errorf("bad --include pattern %q: %w", cfg.include, err)
+	}
+
+	jobs, err := batchJobs(args, cfg)
+	if err != nil {
+		return err
+	}
+
+	work := make(chan batchJob)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failures []batchFailure
+	)
+	for range max(cfg.jobs, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range work {
+				if err := convertFile(job, cfg, newScreen); err != nil {
+					mu.Lock()
+					failures = append(failures, batchFailure{src: job.src, err: err})
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, job := range jobs {
+		work <- job
+	}
+	close(work)
+	wg.Wait()
+
+	log.Printf("Converted %d of %d files into %s", len(jobs)-len(failures), len(jobs), cfg.outputDir)
+	if len(failures) == 0 {
+		return nil
+	}
+	slices.SortFunc(failures, func(a, b batchFailure) int { return strings.Compare(a.src, b.src) })
+	for _, f := range failures {
+		log.Printf("  %s: %v", f.src, f.err)
+	}
+	return fmt.Errorf("convert %d of %d files", len(failures), len(jobs))
+}
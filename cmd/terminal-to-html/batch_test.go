@@ -0,0 +1,167 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOutputPath(t *testing.T) {
+	tests := []struct {
+		rel, format, want string
+	}{
+		{rel: "job.log", format: "html", want: "out/job.log.html"},
+		{rel: "job.log", format: "plain", want: "out/job.log.txt"},
+		{rel: "job.log", format: "json", want: "out/job.log.json"},
+		{rel: "logs/a/job.log", format: "html", want: "out/logs/a/job.log.html"},
+		{rel: "./logs//a/../job.log", format: "html", want: "out/logs/job.log.html"},
+		{rel: "../elsewhere/job.log", format: "html", want: "out/job.log.html"},
+		{rel: "/var/log/job.log", format: "plain", want: "out/job.log.txt"},
+	}
+	for _, test := range tests {
+		got := outputPath("out", filepath.FromSlash(test.rel), test.format)
+		if want := filepath.FromSlash(test.want); got != want {
+			t.Errorf("outputPath(out, %q, %q) = %q, want %q", test.rel, test.format, got, want)
+		}
+	}
+}
+
+// writeFiles creates the files (with slash-separated paths) under dir.
+func writeFiles(t *testing.T, dir string, paths ...string) {
+	t.Helper()
+	for _, p := range paths {
+		p = filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("os.MkdirAll error = %v", err)
+		}
+		if err := os.WriteFile(p, []byte("hello\n"), 0o644); err != nil {
+			t.Fatalf("os.WriteFile error = %v", err)
+		}
+	}
+}
+
+func TestBatchJobs(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFiles(t, ".",
+		"a.log",
+		"b.log",
+		"notes.txt",
+		"logs/c.log",
+		"logs/nested/d.log",
+		"logs/nested/e.txt",
+	)
+
+	tests := []struct {
+		name    string
+		args    []string
+		include string
+		want    []batchJob
+	}{
+		{
+			name: "files",
+			args: []string{"a.log", "notes.txt"},
+			want: []batchJob{
+				{src: "a.log", dst: "out/a.log.html"},
+				{src: "notes.txt", dst: "out/notes.txt.html"},
+			},
+		},
+		{
+			name: "glob",
+			args: []string{"*.log"},
+			want: []batchJob{
+				{src: "a.log", dst: "out/a.log.html"},
+				{src: "b.log", dst: "out/b.log.html"},
+			},
+		},
+		{
+			name:    "directory with --include",
+			args:    []string{"logs"},
+			include: "*.log",
+			want: []batchJob{
+				{src: "logs/c.log", dst: "out/c.log.html"},
+				{src: "logs/nested/d.log", dst: "out/nested/d.log.html"},
+			},
+		},
+		{
+			name:    "directory with every file",
+			args:    []string{"logs/nested"},
+			include: "*",
+			want: []batchJob{
+				{src: "logs/nested/d.log", dst: "out/d.log.html"},
+				{src: "logs/nested/e.txt", dst: "out/e.txt.html"},
+			},
+		},
+		{
+			name: "duplicates are converted once",
+			args: []string{"a.log", "*.log"},
+			want: []batchJob{
+				{src: "a.log", dst: "out/a.log.html"},
+				{src: "b.log", dst: "out/b.log.html"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var args []string
+			for _, a := range test.args {
+				args = append(args, filepath.FromSlash(a))
+			}
+			for i := range test.want {
+				test.want[i].src = filepath.FromSlash(test.want[i].src)
+				test.want[i].dst = filepath.FromSlash(test.want[i].dst)
+			}
+			got, err := batchJobs(args, batchConfig{outputDir: "out", include: test.include, format: "html"})
+			if err != nil {
+				t.Fatalf("batchJobs(%q) error = %v", test.args, err)
+			}
+			if diff := cmp.Diff(got, test.want, cmp.AllowUnexported(batchJob{})); diff != "" {
+				t.Errorf("batchJobs(%q) diff (-got +want):\n%s", test.args, diff)
+			}
+		})
+	}
+}
+
+func TestBatchJobsErrors(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFiles(t, ".", "job.log", "logs/job.log")
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "output paths clash",
+			args: []string{"job.log", "logs"},
+			want: "both job.log and " + filepath.FromSlash("logs/job.log") + " would be written to " + filepath.FromSlash("out/job.log.html"),
+		},
+		{
+			name: "glob matches nothing",
+			args: []string{"*.txt"},
+			want: `no files match "*.txt"`,
+		},
+		{
+			name: "bad glob",
+			args: []string{"[.log"},
+			want: `bad pattern "[.log"`,
+		},
+		{
+			name: "missing file",
+			args: []string{"missing.log"},
+			want: "missing.log",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := batchJobs(test.args, batchConfig{outputDir: "out", include: "*", format: "html"})
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("batchJobs(%q) error = %v, want it to contain %q", test.args, err, test.want)
+			}
+		})
+	}
+}
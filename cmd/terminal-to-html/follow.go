@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/buildkite/terminal-to-html/v3"
+)
+
+// followReader reads a file like `tail -f`: at the end of the file, rather
+// than returning io.EOF, it waits for more data to be appended. It only
+// returns io.EOF once ctx is done.
+type followReader struct {
+	ctx  context.Context
+	f    *os.File
+	poll time.Duration
+}
+
+func (r *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.f.Read(p)
+		if n > 0 {
+			return n, nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+
+		// If the file was truncated (e.g. log rotation with copytruncate),
+		// start again from the beginning.
+		if err := r.rewindIfTruncated(); err != nil {
+			return 0, err
+		}
+
+		select {
+		case <-r.ctx.Done():
+			return 0, io.EOF
+		case <-time.After(r.poll):
+		}
+	}
+}
+
+// rewindIfTruncated seeks to the start of the file if the file is now
+// shorter than the current read offset.
+func (r *followReader) rewindIfTruncated() error {
+	offset, err := r.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	info, err := r.f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < offset {
+		_, err = r.f.Seek(0, io.SeekStart)
+	}
+	return err
+}
+
+// follow renders a file that is still being written to, until interrupted
+// with SIGINT or SIGTERM. Lines are written to dst as they scroll out of the
+// screen buffer, and the remainder is written when following stops.
+func follow(dst io.Writer, path string, poll time.Duration, preview bool, format string, timestamps bool, screen *terminal.Screen) (in, out int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("read %s: %w", path, err)
+	}
+	defer f.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return process(dst, &followReader{ctx: ctx, f: f, poll: poll}, preview, format, timestamps, screen)
+}
+
+// followWebservice renders a file that is still being written to, and serves
+// a page at / that displays it live, like a session (see sessionHub). screen
+// should be a session screen, with a buffer exactly one window in size.
+func followWebservice(cfg webserviceConfig, path string, poll time.Duration, screen *terminal.Screen) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	defer f.Close()
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	s := newSession(screen, time.Now)
+	go func() {
+		if _, err := io.Copy(s, &followReader{ctx: ctx, f: f, poll: poll}); err != nil {
+			log.Printf("error following %s: %v", path, err)
+		}
+	}()
+	return cfg.serve(ctx, followMux(s), s.end)
+}
+
+// followMux serves the page for --follow --http at /, and its events at
+// /events.
+func followMux(s *session) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		serveSessionViewer(w)
+	})
+	mux.HandleFunc("GET /events", s.serveEvents)
+	return mux
+}
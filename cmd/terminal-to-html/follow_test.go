@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildkite/terminal-to-html/v3"
+	"github.com/google/go-cmp/cmp"
+)
+
+// newTestFollowReader opens a file containing content, and follows it until
+// the test ends.
+func newTestFollowReader(t *testing.T, content string) (*followReader, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "job.log")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", path, err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("os.Open(%q) error = %v", path, err)
+	}
+	t.Cleanup(func() { f.Close() })
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return &followReader{ctx: ctx, f: f, poll: time.Millisecond}, path
+}
+
+// readString reads once from r, failing the test on error.
+func readString(t *testing.T, r io.Reader) string {
+	t.Helper()
+	buf := make([]byte, 64)
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	return string(buf[:n])
+}
+
+func appendFile(t *testing.T, path, content string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("os.OpenFile(%q) error = %v", path, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatalf("WriteString error = %v", err)
+	}
+}
+
+func TestFollowReaderWaitsForMore(t *testing.T) {
+	r, path := newTestFollowReader(t, "first\n")
+	if got, want := readString(t, r), "first\n"; got != want {
+		t.Fatalf("Read() = %q, want %q", got, want)
+	}
+
+	// At the end of the file, Read waits for more to be appended.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		appendFile(t, path, "second\n")
+	}()
+	if got, want := readString(t, r), "second\n"; got != want {
+		t.Errorf("Read() = %q, want %q", got, want)
+	}
+}
+
+func TestFollowReaderRewindsAfterTruncation(t *testing.T) {
+	r, path := newTestFollowReader(t, "a long first line\n")
+	if got, want := readString(t, r), "a long first line\n"; got != want {
+		t.Fatalf("Read() = %q, want %q", got, want)
+	}
+
+	// copytruncate-style log rotation: the file is emptied, then written
+	// to again from the start.
+	if err := os.WriteFile(path, []byte("rotated\n"), 0o644); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", path, err)
+	}
+	if got, want := readString(t, r), "rotated\n"; got != want {
+		t.Errorf("Read() after truncation = %q, want %q", got, want)
+	}
+}
+
+func TestFollowReaderStops(t *testing.T) {
+	r, _ := newTestFollowReader(t, "")
+	ctx, cancel := context.WithCancel(context.Background())
+	r.ctx = ctx
+	cancel()
+
+	n, err := r.Read(make([]byte, 8))
+	if n != 0 || err != io.EOF {
+		t.Errorf("Read() after the context is done = (%d, %v), want (0, io.EOF)", n, err)
+	}
+}
+
+func TestFollowMux(t *testing.T) {
+	screen, err := terminal.NewScreen(terminal.WithMaxSize(0, 2), terminal.WithSize(80, 2))
+	if err != nil {
+		t.Fatalf("terminal.NewScreen() error = %v", err)
+	}
+	s := newSession(screen, time.Now)
+	srv := httptest.NewServer(followMux(s))
+	t.Cleanup(srv.Close)
+	t.Cleanup(s.end)
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET / error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "EventSource") {
+		t.Errorf("GET / = %q, want the live viewer page", body)
+	}
+
+	// Lines that scrolled out before subscribing are sent first, then the
+	// screen.
+	if _, err := io.WriteString(s, "one\ntwo\nthree\nfour"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	rd := subscribe(t, t.Context(), srv.URL)
+	if diff := cmp.Diff(readEvent(t, rd), sessionEvent{name: "append", data: "one\ntwo"}, cmp.AllowUnexported(sessionEvent{})); diff != "" {
+		t.Errorf("first event diff (-got +want):\n%s", diff)
+	}
+	if diff := cmp.Diff(readEvent(t, rd), sessionEvent{name: "replace", data: "three\nfour"}, cmp.AllowUnexported(sessionEvent{})); diff != "" {
+		t.Errorf("second event diff (-got +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/buildkite/terminal-to-html/v3"
+)
+
+// jsonSection is a log section as written by --format json.
+type jsonSection struct {
+	Header      string     `json:"header"`
+	Marker      string     `json:"marker"`
+	Expanded    bool       `json:"expanded"`
+	Start       *time.Time `json:"start,omitempty"`
+	End         *time.Time `json:"end,omitempty"`
+	DurationMS  *int64     `json:"duration_ms,omitempty"`
+	Lines       int        `json:"lines"`
+	ExitMarkers []string   `json:"exit_markers"`
+}
+
+// writeSectionsJSON writes sections to dst as a JSON array. Timestamps and
+// durations are omitted when unknown.
+func writeSectionsJSON(dst io.Writer, sections []terminal.Section) error {
+	out := make([]jsonSection, 0, len(sections))
+	for _, s := range sections {
+		js := jsonSection{
+			Header:      s.Header,
+			Marker:      s.Marker,
+			Expanded:    s.Expanded,
+			Lines:       s.Lines,
+			ExitMarkers: s.ExitMarkers,
+		}
+		if js.ExitMarkers == nil {
+			js.ExitMarkers = []string{}
+		}
+		if !s.Start.IsZero() {
+			start, end := s.Start, s.End
+			duration := s.Duration().Milliseconds()
+			js.Start, js.End, js.DurationMS = &start, &end, &duration
+		}
+		out = append(out, js)
+	}
+	enc := json.NewEncoder(dst)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buildkite/terminal-to-html/v3"
+)
+
+const (
+	// Number of scrolled-out lines each session keeps, so that subscribers
+	// arriving late (or reconnecting) can catch up.
+	sessionHistoryLines = 10000
+
+	// Number of events that can be waiting for a subscriber before it is
+	// considered too slow and disconnected. Browsers reconnect automatically.
+	sessionSubscriberBuffer = 256
+
+	// Number of sessions that can exist at once, so that clients can't use
+	// unbounded memory.
+	maxSessions = 100
+
+	// How long a session can go without being written to, while nobody is
+	// subscribed, before it is ended and removed.
+	sessionIdleTimeout = time.Hour
+
+	// How often idle sessions are looked for (see sessionHub.expireIdle).
+	sessionExpiryInterval = time.Minute
+)
+
+// sessionViewer is the page served at GET /sessions/{id}. It subscribes to
+// the events for the session and applies them.
+const sessionViewer = `<div id="term-scrollback"></div><div id="term-window"></div>
+<script>
+(function() {
+	const scrollback = document.getElementById("term-scrollback");
+	const win = document.getElementById("term-window");
+	const follow = () => window.scrollTo(0, document.body.scrollHeight);
+	const events = new EventSource(location.pathname.replace(/\/$/, "") + "/events");
+	// The history is resent on every (re)connection.
+	events.addEventListener("open", () => { scrollback.innerHTML = ""; });
+	events.addEventListener("append", (e) => {
+		scrollback.insertAdjacentHTML("beforeend", e.data + "\n");
+		follow();
+	});
+	events.addEventListener("replace", (e) => {
+		win.innerHTML = e.data;
+		follow();
+	});
+	events.addEventListener("end", () => events.close());
+})();
+</script>`
+
+var errSessionEnded = errors.New("session has ended")
+
+// sessionEvent is a Server-Sent Event.
+type sessionEvent struct {
+	name, data string
+}
+
+// writeTo writes the event in the text/event-stream format.
+func (e sessionEvent) writeTo(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("event: " + e.name + "\n")
+	for line := range strings.SplitSeq(e.data, "\n") {
+		sb.WriteString("data: " + line + "\n")
+	}
+	sb.WriteString("\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// session is a single live log. Raw terminal output written to it is
+// rendered into a screen whose buffer is exactly one window in size. Lines
+// that scroll out of the window can no longer change, so they are sent to
+// subscribers as "append" events. The window itself is sent as "replace"
+// events whenever it changes, so that spinners and progress bars update in
+// place.
+type session struct {
+	mu      sync.Mutex
+	screen  *terminal.Screen
+	history []string // scrolled-out lines, oldest first
+	pending []string // lines scrolled out during the current write
+	window  string   // last window HTML sent
+	subs    map[chan sessionEvent]struct{}
+	ended   bool
+
+	// When the session was last written to, according to now.
+	lastWrite time.Time
+	now       func() time.Time
+}
+
+func newSession(screen *terminal.Screen, now func() time.Time) *session {
+	s := &session{
+		screen:    screen,
+		subs:      make(map[chan sessionEvent]struct{}),
+		lastWrite: now(),
+		now:       now,
+	}
+	screen.ScrollOutFunc = func(line string) {
+		s.pending = append(s.pending, strings.TrimSuffix(line, "\n"))
+	}
+	return s
+}
+
+// Write renders b into the session screen and notifies subscribers.
+func (s *session) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return 0, errSessionEnded
+	}
+
+	n, err := s.screen.Write(b)
+	s.lastWrite = s.now()
+
+	if len(s.pending) > 0 {
+		s.broadcast(sessionEvent{name: "append", data: strings.Join(s.pending, "\n")})
+		s.history = append(s.history, s.pending...)
+		if over := len(s.history) - sessionHistoryLines; over > 0 {
+			s.history = s.history[over:]
+		}
+		s.pending = s.pending[:0]
+	}
+	if window := s.screen.AsHTMLWithTimestamps(s.screen.Timestamps); window != s.window {
+		s.window = window
+		s.broadcast(sessionEvent{name: "replace", data: window})
+	}
+	return n, err
+}
+
+// subscribe registers a new subscriber. The returned channel first receives
+// the history and current window, then each new event. It is closed when the
+// session ends, or if the subscriber falls too far behind.
+func (s *session) subscribe() chan sessionEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch := make(chan sessionEvent, sessionSubscriberBuffer+2)
+	if len(s.history) > 0 {
+		ch <- sessionEvent{name: "append", data: strings.Join(s.history, "\n")}
+	}
+	if s.window != "" {
+		ch <- sessionEvent{name: "replace", data: s.window}
+	}
+	if s.ended {
+		ch <- sessionEvent{name: "end"}
+		close(ch)
+		return ch
+	}
+	s.subs[ch] = struct{}{}
+	return ch
+}
+
+// unsubscribe removes a subscriber, if it is still subscribed.
+func (s *session) unsubscribe(ch chan sessionEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subs[ch]; ok {
+		delete(s.subs, ch)
+		close(ch)
+	}
+}
+
+// idleSince reports whether the session has had no subscribers, and hasn't
+// been written to, since t.
+func (s *session) idleSince(t time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subs) == 0 && s.lastWrite.Before(t)
+}
+
+// end sends a final "end" event and disconnects all subscribers.
+func (s *session) end() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.ended = true
+	s.broadcast(sessionEvent{name: "end"})
+	for ch := range s.subs {
+		delete(s.subs, ch)
+		close(ch)
+	}
+}
+
+// broadcast sends an event to every subscriber. It must be called with s.mu
+// held. Subscribers that can't keep up are disconnected rather than allowed
+// to hold up the producer.
+func (s *session) broadcast(e sessionEvent) {
+	for ch := range s.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(s.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// sessionHub hosts live log sessions over HTTP:
+//
+//   - POST /sessions/{id} renders the (possibly streamed) request body into
+//     the session, creating it if needed. It can be repeated to add more.
+//     Only this creates sessions; the other routes 404 on unknown ids.
+//   - DELETE /sessions/{id} ends the session.
+//   - GET /sessions/{id}/events subscribes to the session's Server-Sent Events.
+//   - GET /sessions/{id} serves a page that displays the session live.
+type sessionHub struct {
+	// newScreen creates the screen for each new session.
+	newScreen func() (*terminal.Screen, error)
+
+	// now returns the current time (for idle expiry). It can be replaced
+	// in tests.
+	now func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]*session
+
+	// stopped is closed by shutdown, to stop expireIdle.
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func newSessionHub(newScreen func() (*terminal.Screen, error)) *sessionHub {
+	return &sessionHub{
+		newScreen: newScreen,
+		now:       time.Now,
+		sessions:  make(map[string]*session),
+		stopped:   make(chan struct{}),
+	}
+}
+
+// expireIdle removes idle sessions every interval, so that abandoned
+// sessions are removed even if no new ones are created (which also removes
+// them). It returns once the hub is shut down.
+func (h *sessionHub) expireIdle(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stopped:
+			return
+		case <-ticker.C:
+			h.mu.Lock()
+			h.expire()
+			h.mu.Unlock()
+		}
+	}
+}
+
+// register adds the session routes to mux.
+func (h *sessionHub) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /sessions/{id}", h.handleWrite)
+	mux.HandleFunc("DELETE /sessions/{id}", h.handleEnd)
+	mux.HandleFunc("GET /sessions/{id}/events", h.handleEvents)
+	mux.HandleFunc("GET /sessions/{id}", h.handleViewer)
+}
+
+// errTooManySessions is returned by create when the session limit is reached.
+var errTooManySessions = errors.New("too many sessions")
+
+// get returns the session with the given id, or nil if it doesn't exist.
+func (h *sessionHub) get(id string) *session {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sessions[id]
+}
+
+// create returns the session with the given id, creating it if it doesn't
+// exist. Idle sessions are removed first, to make room.
+func (h *sessionHub) create(id string) (*session, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s := h.sessions[id]; s != nil {
+		return s, nil
+	}
+	h.expire()
+	if len(h.sessions) >= maxSessions {
+		return nil, errTooManySessions
+	}
+	screen, err := h.newScreen()
+	if err != nil {
+		return nil, err
+	}
+	s := newSession(screen, h.now)
+	h.sessions[id] = s
+	return s, nil
+}
+
+// expire ends and removes the sessions that have been idle for longer than
+// sessionIdleTimeout. It must be called with h.mu held.
+func (h *sessionHub) expire() {
+	cutoff := h.now().Add(-sessionIdleTimeout)
+	for id, s := range h.sessions {
+		if s.idleSince(cutoff) {
+			s.end()
+			delete(h.sessions, id)
+		}
+	}
+}
+
+// shutdown ends every session, so that subscribers disconnect and the server
+// can shut down gracefully.
+func (h *sessionHub) shutdown() {
+	h.stopOnce.Do(func() { close(h.stopped) })
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, s := range h.sessions {
+		s.end()
+		delete(h.sessions, id)
+	}
+}
+
+func (h *sessionHub) handleWrite(w http.ResponseWriter, r *http.Request) {
+	s, err := h.create(r.PathValue("id"))
+	if errors.Is(err, errTooManySessions) {
+		http.Error(w, "Too many sessions.", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Printf("error creating session: %v", err)
+		http.Error(w, "Error creating session.", http.StatusInternalServerError)
+		return
+	}
+
+	// Producers may stream output for as long as the job runs.
+	if err := http.NewResponseController(w).SetReadDeadline(time.Time{}); err != nil {
+		log.Printf("error clearing read deadline: %v", err)
+	}
+	if _, err := io.Copy(s, r.Body); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errSessionEnded) {
+			status = http.StatusConflict
+		}
+		http.Error(w, fmt.Sprintf("Error writing to session: %v", err), status)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *sessionHub) handleEnd(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	s := h.sessions[r.PathValue("id")]
+	delete(h.sessions, r.PathValue("id"))
+	h.mu.Unlock()
+
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.end()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *sessionHub) handleEvents(w http.ResponseWriter, r *http.Request) {
+	s := h.get(r.PathValue("id"))
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.serveEvents(w, r)
+}
+
+// serveEvents streams the session's events to a subscriber until the session
+// ends, or the subscriber disconnects.
+func (s *session) serveEvents(w http.ResponseWriter, r *http.Request) {
+	ch := s.subscribe()
+	defer s.unsubscribe(ch)
+
+	rc := http.NewResponseController(w)
+	// Event streams stay open indefinitely.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("error clearing write deadline: %v", err)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := e.writeTo(w); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (h *sessionHub) handleViewer(w http.ResponseWriter, r *http.Request) {
+	if h.get(r.PathValue("id")) == nil {
+		http.NotFound(w, r)
+		return
+	}
+	serveSessionViewer(w)
+}
+
+// serveSessionViewer serves the sessionViewer page, which subscribes to the
+// events at the page's path followed by /events.
+func serveSessionViewer(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html")
+	if err := writePreviewStart(w); err != nil {
+		log.Printf("error writing response: %v", err)
+		return
+	}
+	io.WriteString(w, sessionViewer)
+	if err := writePreviewEnd(w); err != nil {
+		log.Printf("error writing response: %v", err)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildkite/terminal-to-html/v3"
+	"github.com/google/go-cmp/cmp"
+)
+
+// newTestSessionServer starts a server hosting sessions with a 2-line window.
+func newTestSessionServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	hub := newSessionHub(func() (*terminal.Screen, error) {
+		return terminal.NewScreen(terminal.WithMaxSize(0, 2), terminal.WithSize(80, 2))
+	})
+	mux := http.NewServeMux()
+	hub.register(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	t.Cleanup(hub.shutdown)
+	return srv
+}
+
+// subscribe connects to the event stream for a session.
+func subscribe(t *testing.T, ctx context.Context, url string) *bufio.Reader {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/events", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequestWithContext error = %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s/events error = %v", url, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if got, want := resp.Header.Get("Content-Type"), "text/event-stream"; got != want {
+		t.Fatalf("Content-Type = %q, want %q", got, want)
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+// readEvent reads the next event from an event stream.
+func readEvent(t *testing.T, rd *bufio.Reader) sessionEvent {
+	t.Helper()
+	var e sessionEvent
+	var data []string
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event stream error = %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			e.data = strings.Join(data, "\n")
+			return e
+		}
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			e.name = name
+		}
+		if d, ok := strings.CutPrefix(line, "data: "); ok {
+			data = append(data, d)
+		}
+	}
+}
+
+func post(t *testing.T, url, body string) {
+	t.Helper()
+	resp, err := http.Post(url, "application/octet-stream", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST %s error = %v", url, err)
+	}
+	resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusNoContent; got != want {
+		t.Fatalf("POST %s status = %d, want %d", url, got, want)
+	}
+}
+
+func TestSessionEvents(t *testing.T) {
+	srv := newTestSessionServer(t)
+	url := srv.URL + "/sessions/job-1"
+	post(t, url, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := subscribe(t, ctx, url)
+
+	post(t, url, "one\ntwo\n\x1b[32mthree\x1b[0m\nfour")
+	// A progress bar redrawing the current line.
+	post(t, url, "\rfive")
+
+	want := []sessionEvent{
+		{name: "append", data: "one\ntwo"},
+		{name: "replace", data: "<span class=\"term-fg32\">three</span>\nfour"},
+		{name: "replace", data: "<span class=\"term-fg32\">three</span>\nfive"},
+	}
+	var got []sessionEvent
+	for range want {
+		got = append(got, readEvent(t, events))
+	}
+	if diff := cmp.Diff(got, want, cmp.AllowUnexported(sessionEvent{})); diff != "" {
+		t.Errorf("session events diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestSessionLateSubscriberGetsHistory(t *testing.T) {
+	srv := newTestSessionServer(t)
+	url := srv.URL + "/sessions/job-2"
+
+	post(t, url, "one\ntwo\nthree\nfour")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := subscribe(t, ctx, url)
+
+	want := []sessionEvent{
+		{name: "append", data: "one\ntwo"},
+		{name: "replace", data: "three\nfour"},
+	}
+	var got []sessionEvent
+	for range want {
+		got = append(got, readEvent(t, events))
+	}
+	if diff := cmp.Diff(got, want, cmp.AllowUnexported(sessionEvent{})); diff != "" {
+		t.Errorf("session events diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestSessionEnd(t *testing.T) {
+	srv := newTestSessionServer(t)
+	url := srv.URL + "/sessions/job-3"
+	post(t, url, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := subscribe(t, ctx, url)
+
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest error = %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("DELETE %s error = %v", url, err)
+	}
+	resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusNoContent; got != want {
+		t.Fatalf("DELETE %s status = %d, want %d", url, got, want)
+	}
+
+	if got, want := readEvent(t, events).name, "end"; got != want {
+		t.Errorf("event name = %q, want %q", got, want)
+	}
+	if _, err := events.ReadByte(); err == nil {
+		t.Errorf("event stream still open after end event")
+	}
+}
+
+func TestSessionUnknownID(t *testing.T) {
+	srv := newTestSessionServer(t)
+
+	for _, path := range []string{"/sessions/missing", "/sessions/missing/events"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		resp.Body.Close()
+		if got, want := resp.StatusCode, http.StatusNotFound; got != want {
+			t.Errorf("GET %s status = %d, want %d", path, got, want)
+		}
+	}
+
+	// GETs don't create the session.
+	req, err := http.NewRequest(http.MethodDelete, srv.URL+"/sessions/missing", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest error = %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("DELETE error = %v", err)
+	}
+	resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusNotFound; got != want {
+		t.Errorf("DELETE status = %d, want %d", got, want)
+	}
+}
+
+func TestSessionLimit(t *testing.T) {
+	hub := newSessionHub(func() (*terminal.Screen, error) { return terminal.NewScreen() })
+	t.Cleanup(hub.shutdown)
+
+	for i := range maxSessions {
+		if _, err := hub.create(fmt.Sprint(i)); err != nil {
+			t.Fatalf("hub.create(%d) error = %v", i, err)
+		}
+	}
+	if _, err := hub.create("one-too-many"); !errors.Is(err, errTooManySessions) {
+		t.Errorf("hub.create(one-too-many) error = %v, want %v", err, errTooManySessions)
+	}
+	// Existing sessions can still be written to.
+	if _, err := hub.create("0"); err != nil {
+		t.Errorf("hub.create(0) error = %v", err)
+	}
+}
+
+func TestSessionIdleExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	hub := newSessionHub(func() (*terminal.Screen, error) { return terminal.NewScreen() })
+	hub.now = func() time.Time { return now }
+	t.Cleanup(hub.shutdown)
+
+	idle, err := hub.create("idle")
+	if err != nil {
+		t.Fatalf("hub.create(idle) error = %v", err)
+	}
+	watched, err := hub.create("watched")
+	if err != nil {
+		t.Fatalf("hub.create(watched) error = %v", err)
+	}
+	ch := watched.subscribe()
+	defer watched.unsubscribe(ch)
+
+	now = now.Add(sessionIdleTimeout + time.Second)
+	if _, err := hub.create("new"); err != nil {
+		t.Fatalf("hub.create(new) error = %v", err)
+	}
+
+	if hub.get("idle") != nil {
+		t.Errorf("idle session wasn't removed")
+	}
+	if _, err := idle.Write([]byte("x")); !errors.Is(err, errSessionEnded) {
+		t.Errorf("idle.Write() error = %v, want %v", err, errSessionEnded)
+	}
+	if hub.get("watched") == nil {
+		t.Errorf("session with a subscriber was removed")
+	}
+}
+
+func TestSessionIdleExpiryWithoutNewSessions(t *testing.T) {
+	hub := newSessionHub(func() (*terminal.Screen, error) { return terminal.NewScreen() })
+	t.Cleanup(hub.shutdown)
+
+	if _, err := hub.create("idle"); err != nil {
+		t.Fatalf("hub.create(idle) error = %v", err)
+	}
+	hub.now = func() time.Time { return time.Now().Add(sessionIdleTimeout + time.Second) }
+	go hub.expireIdle(time.Millisecond)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for hub.get("idle") != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("idle session wasn't removed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
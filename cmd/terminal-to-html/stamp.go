@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/buildkite/terminal-to-html/v3"
+)
+
+// stampReader timestamps each line read from r with the time it was read
+// (see terminal.TimestampWriter).
+type stampReader struct {
+	r     io.Reader
+	chunk []byte
+	buf   bytes.Buffer
+	tw    *terminal.TimestampWriter
+}
+
+func newStampReader(r io.Reader) *stampReader {
+	sr := &stampReader{r: r, chunk: make([]byte, 32*1024)}
+	sr.tw = terminal.NewTimestampWriter(&sr.buf)
+	return sr
+}
+
+func (sr *stampReader) Read(p []byte) (int, error) {
+	for sr.buf.Len() == 0 {
+		n, err := sr.r.Read(sr.chunk)
+		// Stamp with the time the data was read, which for a pipe is close
+		// to when it was written.
+		sr.tw.Write(sr.chunk[:n])
+		if err != nil {
+			if sr.buf.Len() > 0 {
+				break
+			}
+			return 0, err
+		}
+	}
+	return sr.buf.Read(p)
+}
@@ -1,15 +1,15 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"runtime"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/buildkite/terminal-to-html/v3"
@@ -23,10 +23,23 @@ const appHelpTemplate = `{{.Name}} - {{.Usage}}
 STDIN/STDOUT USAGE:
   cat input.raw | {{.Name}} [arguments...] > out.html
 
+FOLLOW USAGE:
+  {{.Name}} [arguments...] --follow job.log > out.html
+  {{.Name}} [arguments...] --follow --http :6060 job.log
+
+BATCH USAGE:
+  {{.Name}} [arguments...] --output-dir out/ logs/ 'artifacts/*.raw'
+
 WEBSERVICE USAGE:
   {{.Name}} --http :6060 &
   curl --data-binary "@input.raw" http://localhost:6060/terminal > out.html
 
+  curl -X POST -T job.raw http://localhost:6060/sessions/my-job   # then open this URL in a browser
+  curl -X DELETE http://localhost:6060/sessions/my-job
+
+  {{.Name}} --http unix:/tmp/t2h.sock &
+  curl --unix-socket /tmp/t2h.sock --data-binary "@input.raw" http://localhost/terminal > out.html
+
 OPTIONS:
   {{range .Flags}}{{.}}
   {{end}}
@@ -49,6 +62,29 @@ const (
 		<div class="term-container">`
 
 	previewEpilogue = `</div>
+		<script>
+// Highlight every part of an OSC 8 link with an id when one is hovered.
+for (const type of ['mouseover', 'mouseout']) {
+  document.addEventListener(type, (e) => {
+    const a = e.target.closest && e.target.closest('a[data-link-id]');
+    if (!a) {
+      return;
+    }
+    const sel = 'a[data-link-id="' + CSS.escape(a.dataset.linkId) + '"][href="' + CSS.escape(a.getAttribute('href')) + '"]';
+    for (const part of document.querySelectorAll(sel)) {
+      part.classList.toggle('term-link-hover', type === 'mouseover');
+    }
+  });
+}
+// Expand the log groups containing exit markers (such as the "^^^ +++" the
+// Buildkite agent writes when a command fails).
+for (const exit of document.querySelectorAll('.term-group-exit')) {
+  const group = exit.closest('details');
+  if (group) {
+    group.open = true;
+  }
+}
+		</script>
 	</body>
 </html>
 `
@@ -76,35 +112,10 @@ func writePreviewEnd(w io.Writer) error {
 	return err
 }
 
-func webservice(listen string, preview bool, screen *terminal.Screen) {
-	http.HandleFunc("/terminal", func(w http.ResponseWriter, r *http.Request) {
-		// The main handler passes in an empty screen with an initial window
-		// size. Make a copy per request.
-		screen := *screen
-
-		// Process the request body, but write to a buffer before serving it.
-		// Consuming the body before any writes is necessary because of HTTP
-		// limitations (see http.ResponseWriter):
-		// > Depending on the HTTP protocol version and the client, calling
-		// > Write or WriteHeader may prevent future reads on the
-		// > Request.Body.
-		// However, it lets us provide Content-Length in all cases.
-		b := bytes.NewBuffer(nil)
-		if _, _, err := process(b, r.Body, preview, "html", false, &screen); err != nil {
-			log.Printf("error starting preview: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprint(w, "Error creating preview.")
-		}
-
-		w.Header().Set("Content-Type", "text/html")
-		w.Header().Set("Content-Length", strconv.Itoa(b.Len()))
-		if _, err := w.Write(b.Bytes()); err != nil {
-			log.Printf("error writing response: %v", err)
-		}
-	})
-
-	log.Printf("Listening on %s", listen)
-	log.Fatal(http.ListenAndServe(listen, nil))
+// logAssetError logs an error storing an image in --asset-dir, which is only
+// shown as a placeholder in the output.
+func logAssetError(err error) {
+	log.Printf("error storing image: %v", err)
 }
 
 func logStats(start time.Time, in, out int, s *terminal.Screen) {
@@ -191,11 +202,13 @@ func process(dst io.Writer, src io.Reader, preview bool, format string, timestam
 		}
 	}
 
-	// Attach the scrollout callback before streaming input.
+	// Attach the scrollout callback before streaming input. JSON output is
+	// only written once all the input has been read.
 	screen.Timestamps = timestamps
-	if format == "html" {
+	switch format {
+	case "html":
 		screen.ScrollOutFunc = wc.WriteString
-	} else {
+	case "plain":
 		screen.ScrollOutPlainFunc = wc.WriteString
 	}
 
@@ -206,9 +219,14 @@ func process(dst io.Writer, src io.Reader, preview bool, format string, timestam
 
 	// Write what remains in the screen buffer (everything that didn't scroll
 	// out of the top).
-	if format == "plain" {
+	switch format {
+	case "plain":
 		wc.WriteString(screen.AsPlainTextWithTimestamps(timestamps))
-	} else {
+	case "json":
+		if err := writeSectionsJSON(wc, screen.Sections()); err != nil {
+			return int(inBytes), wc.counter, fmt.Errorf("write sections: %w", err)
+		}
+	default:
 		wc.WriteString(screen.AsHTMLWithTimestamps(timestamps))
 	}
 
@@ -220,6 +238,23 @@ func process(dst io.Writer, src io.Reader, preview bool, format string, timestam
 	return int(inBytes), wc.counter, nil
 }
 
+// timestampLayout returns the time layout for a --timestamp-format value,
+// which is either a named layout or a layout itself.
+func timestampLayout(format string) string {
+	switch format {
+	case "rfc3339":
+		return time.RFC3339
+	case "rfc3339-ms":
+		return "2006-01-02T15:04:05.000Z07:00"
+	case "time":
+		return time.TimeOnly
+	case "time-ms":
+		return "15:04:05.000"
+	default:
+		return format
+	}
+}
+
 func main() {
 	cli.AppHelpTemplate = appHelpTemplate
 
@@ -232,7 +267,33 @@ func main() {
 		&cli.StringFlag{
 			Name:  "http",
 			Value: "",
-			Usage: "HTTP service mode (eg --http :6060), endpoint is /terminal",
+			Usage: "HTTP service mode (eg --http :6060, or --http unix:/path/to/socket), endpoints are /terminal and /sessions/{id}",
+		},
+		&cli.DurationFlag{
+			Name:  "http-read-timeout",
+			Usage: "In HTTP service mode, the maximum duration for reading an entire request, including the body. Zero means no timeout",
+		},
+		&cli.DurationFlag{
+			Name:  "http-write-timeout",
+			Usage: "In HTTP service mode, the maximum duration before timing out writes of the response. Zero means no timeout",
+		},
+		&cli.IntFlag{
+			Name:  "http-max-header-bytes",
+			Value: 1 << 20,
+			Usage: "In HTTP service mode, the maximum number of bytes the server will read parsing request headers",
+		},
+		&cli.DurationFlag{
+			Name:  "http-shutdown-timeout",
+			Value: 30 * time.Second,
+			Usage: "In HTTP service mode, how long to wait for in-flight requests to finish after receiving SIGINT or SIGTERM",
+		},
+		&cli.StringFlag{
+			Name:  "tls-cert",
+			Usage: "In HTTP service mode, path to a PEM certificate file; serves HTTPS when used with --tls-key",
+		},
+		&cli.StringFlag{
+			Name:  "tls-key",
+			Usage: "In HTTP service mode, path to a PEM private key file; serves HTTPS when used with --tls-cert",
 		},
 		&cli.BoolFlag{
 			Name:  "preview",
@@ -241,12 +302,150 @@ func main() {
 		&cli.StringFlag{
 			Name:  "format",
 			Value: "html",
-			Usage: "output format: 'html' or 'plain' for plain text",
+			Usage: "output format: 'html', 'plain' for plain text, or 'json' for a summary of each Buildkite log group (header, timestamps, duration, line count and exit markers)",
 		},
 		&cli.BoolFlag{
 			Name:  "no-timestamps",
 			Usage: "disable timestamps in output",
 		},
+		&cli.StringFlag{
+			Name:  "timestamp-format",
+			Usage: "layout of timestamps: 'rfc3339', 'rfc3339-ms', 'time', 'time-ms', or a Go time layout (e.g. '2006-01-02 15:04:05.000 MST'). Defaults to RFC 3339, with milliseconds in HTML output",
+		},
+		&cli.StringFlag{
+			Name:  "tz",
+			Usage: "time zone to show timestamps in, e.g. 'Australia/Melbourne' or 'Local' (default UTC)",
+		},
+		&cli.BoolFlag{
+			Name:  "relative-timestamps",
+			Usage: "show timestamps relative to the first timestamp in the input, as +hh:mm:ss.mmm",
+		},
+		&cli.BoolFlag{
+			Name:  "arrival-timestamps",
+			Usage: "Timestamp each line read from stdin with the time it arrived, for logs without Buildkite timestamps",
+		},
+		&cli.BoolFlag{
+			Name:  "emit-bk-timestamps",
+			Usage: "Instead of rendering, copy stdin to stdout with each line timestamped with the time it arrived (as a Buildkite timestamp escape sequence)",
+		},
+		&cli.StringFlag{
+			Name:  "since",
+			Usage: "only output lines timestamped at or after this time: an RFC 3339 time, or a duration (eg 1m30s or 00:01:30) after the first timestamp",
+		},
+		&cli.StringFlag{
+			Name:  "until",
+			Usage: "only output lines timestamped at or before this time, in the same formats as --since",
+		},
+		&cli.BoolFlag{
+			Name:  "elapsed",
+			Usage: "show how long each line took (the time until the next line's timestamp)",
+		},
+		&cli.DurationFlag{
+			Name:  "slow-threshold",
+			Usage: "highlight lines that took this long or longer (e.g. 5s): with the term-slow class in HTML, or a ! in the elapsed column of plain text",
+		},
+		&cli.StringSliceFlag{
+			Name:  "metadata-attributes",
+			Usage: "Buildkite metadata keys (from ESC _ bk;key=value BEL sequences) to render as data-bk-<key> attributes on each line, or '*' for all of them. Use 'namespace.key' or 'namespace.*' for metadata from --apc-namespaces",
+		},
+		&cli.StringSliceFlag{
+			Name:  "apc-namespaces",
+			Usage: "APC namespaces besides bk whose key=value pairs (from ESC _ namespace;key=value BEL sequences) are kept as line metadata",
+		},
+		&cli.BoolFlag{
+			Name:  "linkify-urls",
+			Usage: "turn bare http and https URLs into links",
+		},
+		&cli.StringFlag{
+			Name:  "link-files",
+			Usage: "turn file paths with line numbers (e.g. path/to/file.go:123:4) into links to this URL, with {path}, {line} and {col} replaced, e.g. 'https://github.com/org/repo/blob/<commit>/{path}#L{line}'",
+		},
+		&cli.StringFlag{
+			Name:  "link-issues",
+			Usage: "turn issue references (e.g. #1234) into links to this URL, with {number} replaced, e.g. 'https://github.com/org/repo/issues/{number}'",
+		},
+		&cli.StringFlag{
+			Name:  "link-tickets",
+			Usage: "turn ticket references (e.g. JIRA-123) into links to this URL, with {key}, {project} and {number} replaced, e.g. 'https://example.atlassian.net/browse/{key}'",
+		},
+		&cli.StringSliceFlag{
+			Name:  "ticket-projects",
+			Usage: "With --link-tickets, the only project keys (e.g. JIRA) to link. By default, any uppercase key is linked",
+		},
+		&cli.IntFlag{
+			Name:  "image-max-size",
+			Usage: "the largest inline image, in bytes, to embed in the output; larger images are replaced with a placeholder. 0 means no limit",
+		},
+		&cli.StringSliceFlag{
+			Name:  "image-types",
+			Usage: "the only content types of inline images to embed in the output (e.g. image/png,image/jpeg, image/* for any image including SVG, or */* for anything); others are replaced with a placeholder",
+			Value: cli.NewStringSlice("image/png", "image/jpeg", "image/gif", "image/webp"),
+		},
+		&cli.StringFlag{
+			Name:  "asset-dir",
+			Usage: "write inline images to files in this directory, named by a hash of their content, and refer to them by URL instead of embedding them in the HTML",
+		},
+		&cli.StringFlag{
+			Name:  "asset-url-prefix",
+			Usage: "With --asset-dir, the URL that the directory is served from (e.g. https://example.com/assets/), which image file names are appended to. Defaults to --asset-dir itself, as a relative URL, for a single output written to the current directory; required with --output-dir and --http",
+		},
+		&cli.StringFlag{
+			Name:  "artifact-url",
+			Usage: "link artifact elements (1342) to this URL, with {path} replaced by the artifact's path (e.g. https://example.com/artifacts/{path})",
+		},
+		&cli.BoolFlag{
+			Name:  "lazy-images",
+			Usage: "add loading=\"lazy\" to images, so browsers only load them when they're scrolled into view",
+		},
+		&cli.BoolFlag{
+			Name:  "external-links",
+			Usage: "open links to hosts other than --internal-hosts in a new tab, with rel=\"noopener noreferrer nofollow\" and no referrer",
+		},
+		&cli.StringSliceFlag{
+			Name:  "internal-hosts",
+			Usage: "With --external-links, hosts whose links are left as they are; '*.example.com' also matches subdomains of example.com. Links without a host are always internal",
+		},
+		&cli.StringSliceFlag{
+			Name:  "allowed-url-schemes",
+			Usage: "the only URL schemes allowed in links and images (e.g. https,mailto); others are replaced with '#'. By default, any scheme is allowed except javascript, vbscript and data",
+		},
+		&cli.StringSliceFlag{
+			Name:  "allowed-url-hosts",
+			Usage: "the only hosts allowed in links and images; '*.example.com' also allows subdomains of example.com",
+		},
+		&cli.StringFlag{
+			Name:  "relative-urls",
+			Value: "allow",
+			Usage: "how to handle links and images without a URL scheme: 'allow', 'deny', or an absolute URL to resolve them against",
+		},
+		&cli.BoolFlag{
+			Name:  "no-groups",
+			Usage: "don't render Buildkite log groups (lines beginning with ---, +++ or ~~~) as collapsible sections",
+		},
+		&cli.StringFlag{
+			Name:    "output-dir",
+			Aliases: []string{"o"},
+			Usage:   "Convert every input file, glob and directory given as an argument, writing each output to a mirrored path in this directory. Required when there is more than one input",
+		},
+		&cli.StringFlag{
+			Name:  "include",
+			Value: "*",
+			Usage: "With --output-dir, only convert files within input directories whose names match this pattern (eg '*.log')",
+		},
+		&cli.IntFlag{
+			Name:  "jobs",
+			Value: runtime.NumCPU(),
+			Usage: "With --output-dir, the number of files to convert concurrently",
+		},
+		&cli.BoolFlag{
+			Name:  "follow",
+			Usage: "Keep reading the input file as it grows (like tail -f), writing lines as they scroll out of the screen buffer, until interrupted. With --http, serves a page at / that displays the output live instead, like a session (without groups)",
+		},
+		&cli.DurationFlag{
+			Name:  "follow-poll-interval",
+			Value: 250 * time.Millisecond,
+			Usage: "With --follow, how often to check the input file for new data",
+		},
 		&cli.BoolFlag{
 			Name:  "log-stats-to-stderr",
 			Usage: "Logs a JSON object to stderr containing resource and processing statistics after successfully processing",
@@ -275,29 +474,232 @@ func main() {
 	app.Action = func(c *cli.Context) error {
 		// Validate format flag
 		format := c.String("format")
-		if format != "html" && format != "plain" {
-			return fmt.Errorf("invalid format %q: must be 'html' or 'plain'", format)
+		switch format {
+		case "html", "plain":
+		case "json":
+			if c.Bool("no-groups") {
+				return fmt.Errorf("invalid format %q: --no-groups disables the log groups it summarises", format)
+			}
+			if c.Bool("preview") {
+				return fmt.Errorf("invalid format %q: --preview requires HTML output", format)
+			}
+		default:
+			return fmt.Errorf("invalid format %q: must be 'html', 'plain' or 'json'", format)
 		}
 
-		screen, err := terminal.NewScreen(
-			terminal.WithMaxSize(c.Int("window-max-cols"), c.Int("buffer-max-lines")),
-			terminal.WithSize(c.Int("window-cols"), c.Int("window-lines")),
+		// Options for how output is rendered, shared by every kind of screen.
+		renderOpts := []terminal.ScreenOption{
+			terminal.WithMetadataAttributes(c.StringSlice("metadata-attributes")...),
+		}
+		for _, ns := range c.StringSlice("apc-namespaces") {
+			renderOpts = append(renderOpts, terminal.WithAPCHandler(ns, terminal.KeyValueAPCHandler))
+		}
+		if f := c.String("timestamp-format"); f != "" {
+			renderOpts = append(renderOpts, terminal.WithTimestampLayout(timestampLayout(f)))
+		}
+		if tz := c.String("tz"); tz != "" {
+			loc, err := time.LoadLocation(tz)
+			if err != nil {
+				return fmt.Errorf("load time zone %q: %w", tz, err)
+			}
+			renderOpts = append(renderOpts, terminal.WithTimestampLocation(loc))
+		}
+		if c.Bool("relative-timestamps") {
+			renderOpts = append(renderOpts, terminal.WithRelativeTimestamps(true))
+		}
+		policy := terminal.URLPolicy{
+			AllowedSchemes: c.StringSlice("allowed-url-schemes"),
+			AllowedHosts:   c.StringSlice("allowed-url-hosts"),
+		}
+		switch rel := c.String("relative-urls"); rel {
+		case "allow":
+		case "deny":
+			policy.DenyRelative = true
+		default:
+			base, err := url.Parse(rel)
+			if err != nil || !base.IsAbs() {
+				return fmt.Errorf("parse --relative-urls %q: must be 'allow', 'deny' or an absolute URL", rel)
+			}
+			policy.BaseURL = base
+		}
+		renderOpts = append(renderOpts, terminal.WithURLPolicy(policy))
+		var linkRules []terminal.LinkRule
+		if c.Bool("linkify-urls") {
+			linkRules = append(linkRules, terminal.URLLinks())
+		}
+		if u := c.String("link-files"); u != "" {
+			linkRules = append(linkRules, terminal.FileLinks(u))
+		}
+		if u := c.String("link-issues"); u != "" {
+			linkRules = append(linkRules, terminal.IssueLinks(u))
+		}
+		if u := c.String("link-tickets"); u != "" {
+			linkRules = append(linkRules, terminal.TicketLinks(u, c.StringSlice("ticket-projects")...))
+		}
+		renderOpts = append(renderOpts, terminal.WithLinkifier(linkRules...))
+		renderOpts = append(renderOpts, terminal.WithImagePolicy(terminal.ImagePolicy{
+			MaxSize:             c.Int("image-max-size"),
+			AllowedContentTypes: c.StringSlice("image-types"),
+			Lazy:                c.Bool("lazy-images"),
+		}))
+		if dir := c.String("asset-dir"); dir != "" {
+			prefix := c.String("asset-url-prefix")
+			if prefix == "" {
+				// The directory is only a usable relative URL for a
+				// single output, read from the current directory. Batch
+				// outputs are written elsewhere, and the web service
+				// doesn't serve the directory at all.
+				if c.String("output-dir") != "" || c.Args().Len() > 1 || c.String("http") != "" {
+					return fmt.Errorf("use --asset-dir with --output-dir or --http: --asset-url-prefix is required")
+				}
+				prefix = strings.TrimSuffix(filepath.ToSlash(dir), "/") + "/"
+			}
+			renderOpts = append(renderOpts, terminal.WithAssetSink(terminal.NewFileAssetSink(dir, prefix)))
+		} else if c.String("asset-url-prefix") != "" {
+			return fmt.Errorf("use --asset-url-prefix: it requires --asset-dir")
+		}
+		if u := c.String("artifact-url"); u != "" {
+			renderOpts = append(renderOpts, terminal.WithArtifactURL(u))
+		}
+		if c.Bool("external-links") {
+			renderOpts = append(renderOpts, terminal.WithLinkAttributes(terminal.ExternalLinkAttributes(c.StringSlice("internal-hosts")...)))
+		}
+		var since, until terminal.TimeBound
+		if v := c.String("since"); v != "" {
+			b, err := terminal.ParseTimeBound(v)
+			if err != nil {
+				return fmt.Errorf("parse --since: %w", err)
+			}
+			since = b
+		}
+		if v := c.String("until"); v != "" {
+			b, err := terminal.ParseTimeBound(v)
+			if err != nil {
+				return fmt.Errorf("parse --until: %w", err)
+			}
+			until = b
+		}
+		renderOpts = append(renderOpts,
+			terminal.WithTimeRange(since, until),
+			terminal.WithElapsed(c.Bool("elapsed")),
+			terminal.WithSlowThreshold(c.Duration("slow-threshold")),
 		)
-		if err != nil {
-			return fmt.Errorf("creating screen: %w", err)
+
+		newScreen := func() (*terminal.Screen, error) {
+			screen, err := terminal.NewScreen(append([]terminal.ScreenOption{
+				terminal.WithMaxSize(c.Int("window-max-cols"), c.Int("buffer-max-lines")),
+				terminal.WithSize(c.Int("window-cols"), c.Int("window-lines")),
+				terminal.WithGroups(!c.Bool("no-groups")),
+			}, renderOpts...)...)
+			if err != nil {
+				return nil, fmt.Errorf("creating screen: %w", err)
+			}
+			screen.Timestamps = !c.Bool("no-timestamps")
+			screen.AssetErrorFunc = logAssetError
+			return screen, nil
 		}
-		screen.Timestamps = !c.Bool("no-timestamps")
 
-		// Run a web server?
-		if addr := c.String("http"); addr != "" {
-			webservice(addr, c.Bool("preview"), screen)
+		// The screen buffer for live sessions (including --follow --http) is
+		// exactly one window, so that every line that scrolls out of the
+		// window can be sent to viewers as final. Groups are not rendered,
+		// since each event must be a self-contained HTML fragment.
+		newSessionScreen := func() (*terminal.Screen, error) {
+			screen, err := terminal.NewScreen(append([]terminal.ScreenOption{
+				terminal.WithMaxSize(c.Int("window-max-cols"), c.Int("window-lines")),
+				terminal.WithSize(c.Int("window-cols"), c.Int("window-lines")),
+			}, renderOpts...)...)
+			if err != nil {
+				return nil, fmt.Errorf("creating screen: %w", err)
+			}
+			screen.Timestamps = !c.Bool("no-timestamps")
+			screen.AssetErrorFunc = logAssetError
+			return screen, nil
+		}
+
+		httpConfig := webserviceConfig{
+			listen:          c.String("http"),
+			preview:         c.Bool("preview"),
+			readTimeout:     c.Duration("http-read-timeout"),
+			writeTimeout:    c.Duration("http-write-timeout"),
+			maxHeaderBytes:  c.Int("http-max-header-bytes"),
+			shutdownTimeout: c.Duration("http-shutdown-timeout"),
+			tlsCert:         c.String("tls-cert"),
+			tlsKey:          c.String("tls-key"),
+		}
+
+		// Timestamps for lines as they arrive can only come from stdin.
+		if c.Bool("arrival-timestamps") || c.Bool("emit-bk-timestamps") {
+			if c.Args().Len() > 0 || c.Bool("follow") || httpConfig.listen != "" {
+				return fmt.Errorf("timestamp lines on arrival: input must be read from stdin")
+			}
+		}
+		if c.Bool("emit-bk-timestamps") {
+			if _, err := io.Copy(terminal.NewTimestampWriter(os.Stdout), os.Stdin); err != nil {
+				return fmt.Errorf("timestamp stdin: %w", err)
+			}
+			return nil
+		}
+
+		// Follow a file that is still being written?
+		if c.Bool("follow") {
+			if c.Args().Len() != 1 {
+				return fmt.Errorf("follow %d inputs: --follow requires exactly one file", c.Args().Len())
+			}
+			fpath := c.Args().Get(0)
+			poll := c.Duration("follow-poll-interval")
+
+			if httpConfig.listen != "" {
+				screen, err := newSessionScreen()
+				if err != nil {
+					return err
+				}
+				return followWebservice(httpConfig, fpath, poll, screen)
+			}
+			screen, err := newScreen()
+			if err != nil {
+				return err
+			}
+
+			start := time.Now()
+			in, out, err := follow(os.Stdout, fpath, poll, c.Bool("preview"), format, !c.Bool("no-timestamps"), screen)
+			if err != nil {
+				return err
+			}
+			if c.Bool("log-stats-to-stderr") {
+				logStats(start, in, out, screen)
+			}
 			return nil
 		}
 
+		// Run a web server?
+		if httpConfig.listen != "" {
+			return webservice(httpConfig, newScreen, newSessionScreen)
+		}
+
+		// Convert many files?
+		if outDir := c.String("output-dir"); outDir != "" || c.Args().Len() > 1 {
+			if outDir == "" {
+				return fmt.Errorf("convert %d inputs: --output-dir is required", c.Args().Len())
+			}
+			return batch(c.Args().Slice(), batchConfig{
+				outputDir:  outDir,
+				include:    c.String("include"),
+				jobs:       c.Int("jobs"),
+				preview:    c.Bool("preview"),
+				format:     format,
+				timestamps: !c.Bool("no-timestamps"),
+			}, newScreen)
+		}
+
+		screen, err := newScreen()
+		if err != nil {
+			return err
+		}
+
 		start := time.Now()
 
 		// Read input from either stdin or a file.
-		input := os.Stdin
+		var input io.Reader = os.Stdin
 		if args := c.Args(); args.Len() > 0 {
 			fpath := args.Get(0)
 			f, err := os.Open(fpath)
@@ -306,6 +708,9 @@ func main() {
 			}
 			input = f
 		}
+		if c.Bool("arrival-timestamps") {
+			input = newStampReader(input)
+		}
 
 		in, out, err := process(os.Stdout, input, c.Bool("preview"), format, !c.Bool("no-timestamps"), screen)
 		if err != nil {
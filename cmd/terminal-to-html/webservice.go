@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/buildkite/terminal-to-html/v3"
+)
+
+// webserviceConfig holds the settings for --http mode.
+type webserviceConfig struct {
+	// Address to listen on, either host:port or unix:/path/to/socket.
+	listen string
+
+	// Wrap each response in the preview HTML & CSS.
+	preview bool
+
+	// Limits applied to every connection (see http.Server).
+	readTimeout    time.Duration
+	writeTimeout   time.Duration
+	maxHeaderBytes int
+
+	// How long to wait for in-flight requests to finish after receiving
+	// SIGINT or SIGTERM.
+	shutdownTimeout time.Duration
+
+	// If both are set, serve HTTPS instead of HTTP.
+	tlsCert, tlsKey string
+}
+
+// listener opens the listening socket described by cfg.listen.
+func (cfg *webserviceConfig) listener() (net.Listener, error) {
+	if path, ok := strings.CutPrefix(cfg.listen, "unix:"); ok {
+		if path == "" {
+			return nil, errors.New("unix socket path is empty")
+		}
+		if err := removeStaleSocket(path); err != nil {
+			return nil, err
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", cfg.listen)
+}
+
+// removeStaleSocket removes the unix socket at path if nothing is listening
+// on it, such as one left behind by a process that crashed. Anything else at
+// path is left alone, so that listening fails.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil || fi.Mode().Type() != os.ModeSocket {
+		return nil
+	}
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return nil
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("remove stale socket: %w", err)
+	}
+	return nil
+}
+
+// webservice serves the /terminal endpoint, which renders each request body
+// with a screen from newScreen, and the live log sessions (see sessionHub),
+// which each use a screen from newSessionScreen.
+func webservice(cfg webserviceConfig, newScreen, newSessionScreen func() (*terminal.Screen, error)) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/terminal", func(w http.ResponseWriter, r *http.Request) {
+		// Each request gets its own screen. (Copying a Screen value isn't
+		// enough, because the parser within it points back to the original.)
+		screen, err := newScreen()
+		if err != nil {
+			log.Printf("error creating screen: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Error creating preview.")
+			return
+		}
+
+		// Process the request body, but write to a buffer before serving it.
+		// Consuming the body before any writes is necessary because of HTTP
+		// limitations (see http.ResponseWriter):
+		// > Depending on the HTTP protocol version and the client, calling
+		// > Write or WriteHeader may prevent future reads on the
+		// > Request.Body.
+		// However, it lets us provide Content-Length in all cases.
+		b := bytes.NewBuffer(nil)
+		if _, _, err := process(b, r.Body, cfg.preview, "html", false, screen); err != nil {
+			log.Printf("error starting preview: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Error creating preview.")
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Length", strconv.Itoa(b.Len()))
+		if _, err := w.Write(b.Bytes()); err != nil {
+			log.Printf("error writing response: %v", err)
+		}
+	})
+
+	hub := newSessionHub(newSessionScreen)
+	hub.register(mux)
+	go hub.expireIdle(sessionExpiryInterval)
+
+	ctx, stop := signalContext()
+	defer stop()
+	return cfg.serve(ctx, mux, hub.shutdown)
+}
+
+// signalContext returns a context that is cancelled on SIGINT or SIGTERM.
+// After the first signal, it stops listening for them, so that a second one
+// kills the process the usual way.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	return ctx, stop
+}
+
+// serve serves HTTP (or HTTPS) requests using the handler until ctx is done
+// (see signalContext), and then waits for in-flight requests to finish.
+// If onShutdown is not nil, it is called when shutdown begins, and should
+// make any long-lived requests finish.
+func (cfg *webserviceConfig) serve(ctx context.Context, handler http.Handler, onShutdown func()) error {
+	if (cfg.tlsCert == "") != (cfg.tlsKey == "") {
+		return errors.New("--tls-cert and --tls-key must be used together")
+	}
+
+	srv := &http.Server{
+		Handler:        handler,
+		ReadTimeout:    cfg.readTimeout,
+		WriteTimeout:   cfg.writeTimeout,
+		MaxHeaderBytes: cfg.maxHeaderBytes,
+	}
+	if onShutdown != nil {
+		srv.RegisterOnShutdown(onShutdown)
+	}
+
+	ln, err := cfg.listener()
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", cfg.listen, err)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on %s", cfg.listen)
+		if cfg.tlsCert != "" {
+			serveErr <- srv.ServeTLS(ln, cfg.tlsCert, cfg.tlsKey)
+		} else {
+			serveErr <- srv.Serve(ln)
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, waiting up to %v for requests to finish", cfg.shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutting down: %w", err)
+	}
+	return nil
+}
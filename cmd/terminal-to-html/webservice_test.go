@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unixClient returns an HTTP client that connects to the unix socket at path.
+func unixClient(path string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+func TestListenerUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "t2h.sock")
+	cfg := webserviceConfig{listen: "unix:" + path}
+
+	ln, err := cfg.listener()
+	if err != nil {
+		t.Fatalf("cfg.listener() error = %v", err)
+	}
+	defer ln.Close()
+	if got, want := ln.Addr().Network(), "unix"; got != want {
+		t.Errorf("ln.Addr().Network() = %q, want %q", got, want)
+	}
+
+	// A socket that's in use isn't removed.
+	if _, err := cfg.listener(); err == nil {
+		t.Errorf("cfg.listener() on a socket in use error = nil, want an error")
+	}
+}
+
+func TestListenerStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "t2h.sock")
+
+	// Leave a socket file behind, as a crashed process would.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("net.Listen(unix, %q) error = %v", path, err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	cfg := webserviceConfig{listen: "unix:" + path}
+	ln, err := cfg.listener()
+	if err != nil {
+		t.Fatalf("cfg.listener() with a stale socket error = %v", err)
+	}
+	ln.Close()
+}
+
+func TestListenerErrors(t *testing.T) {
+	notSocket := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(notSocket, []byte("keep me"), 0o644); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", notSocket, err)
+	}
+
+	for _, listen := range []string{"unix:", "unix:" + notSocket, "not an address"} {
+		cfg := webserviceConfig{listen: listen}
+		if ln, err := cfg.listener(); err == nil {
+			ln.Close()
+			t.Errorf("webserviceConfig{listen: %q}.listener() error = nil, want an error", listen)
+		}
+	}
+	if _, err := os.Stat(notSocket); err != nil {
+		t.Errorf("os.Stat(%q) error = %v, want the file to be left alone", notSocket, err)
+	}
+}
+
+func TestServeTLSFlags(t *testing.T) {
+	for _, cfg := range []webserviceConfig{
+		{listen: "127.0.0.1:0", tlsCert: "cert.pem"},
+		{listen: "127.0.0.1:0", tlsKey: "key.pem"},
+	} {
+		err := cfg.serve(context.Background(), http.NotFoundHandler(), nil)
+		if err == nil || !strings.Contains(err.Error(), "must be used together") {
+			t.Errorf("serve() with tlsCert=%q, tlsKey=%q error = %v, want them to be required together", cfg.tlsCert, cfg.tlsKey, err)
+		}
+	}
+}
+
+func TestServeGracefulShutdown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "t2h.sock")
+	cfg := webserviceConfig{listen: "unix:" + path, shutdownTimeout: 10 * time.Second}
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+		io.WriteString(w, "ok")
+	})
+	shutdown := make(chan struct{})
+	onShutdown := func() {
+		close(shutdown)
+		close(release)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- cfg.serve(ctx, handler, onShutdown) }()
+
+	client := unixClient(path)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		resp, err := client.Get("http://t2h/")
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server didn't start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	slow := make(chan string, 1)
+	go func() {
+		resp, err := client.Get("http://t2h/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		slow <- string(body)
+	}()
+	<-started
+
+	// As if the server received SIGINT.
+	cancel()
+
+	select {
+	case <-shutdown:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("onShutdown wasn't called")
+	}
+	if got, want := <-slow, "ok"; got != want {
+		t.Errorf("in-flight request got %q, want %q", got, want)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serve() error = %v", err)
+	}
+}
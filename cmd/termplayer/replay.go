@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/buildkite/terminal-to-html/v3"
+	"github.com/buildkite/terminal-to-html/v3/internal/assets"
+)
+
+// replayFrame is a snapshot of the screen in an HTML replay.
+type replayFrame struct {
+	// Duration is how long the frame is shown, in milliseconds.
+	Duration int64 `json:"d"`
+
+	// HTML is the screen contents.
+	HTML string `json:"h"`
+}
+
+// recordReplay plays back a log into a screen of the given size, and returns
+// a snapshot of the screen at each timestamp where it changed.
+func recordReplay(p *player, rd *bufio.Reader, cols, lines int) ([]replayFrame, error) {
+	// Like a real terminal, the screen only holds the window.
+	screen, err := terminal.NewScreen(terminal.WithMaxSize(0, lines), terminal.WithSize(cols, lines))
+	if err != nil {
+		return nil, fmt.Errorf("creating screen: %w", err)
+	}
+
+	var frames []replayFrame
+	snapshot := func(d time.Duration) {
+		html := screen.AsHTMLWithTimestamps(false)
+		if n := len(frames); n > 0 && frames[n-1].HTML == html {
+			frames[n-1].Duration += d.Milliseconds()
+			return
+		}
+		frames = append(frames, replayFrame{Duration: d.Milliseconds(), HTML: html})
+	}
+
+	p.out = screen
+	p.delay = func(d time.Duration) error {
+		if d > 0 {
+			snapshot(d)
+		}
+		return nil
+	}
+	if err := p.buildkiteModeOutput(rd); err != nil {
+		return nil, err
+	}
+	snapshot(0)
+	return frames, nil
+}
+
+// writeReplay writes a self-contained HTML file to path that replays the log.
+func writeReplay(path string, p *player, rd *bufio.Reader) error {
+	frames, err := recordReplay(p, rd, *htmlCols, *htmlLines)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	if err := writeReplayHTML(f, frames); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+const (
+	replayPrologue = `<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="UTF-8">
+		<title>termplayer replay</title>
+		<style>`
+
+	replayInterlogue = `
+.replay-controls { display: flex; align-items: center; gap: 1ex; margin-bottom: 1em; font-family: sans-serif; }
+.replay-controls input[type=range] { flex: 1; }
+.replay-controls output { font-family: monospace; }
+</style>
+	</head>
+	<body>
+		<div class="replay-controls">
+			<button id="play" type="button">Play</button>
+			<input id="scrubber" type="range" min="0" value="0" step="1">
+			<output id="time">0:00</output>
+		</div>
+		<div class="term-container" id="screen"></div>
+		<script type="application/json" id="frames">`
+
+	replayEpilogue = `</script>
+		<script>
+(function() {
+  const frames = JSON.parse(document.getElementById('frames').textContent);
+  const screen = document.getElementById('screen');
+  const play = document.getElementById('play');
+  const scrubber = document.getElementById('scrubber');
+  const timeLabel = document.getElementById('time');
+
+  // Frame start times, in milliseconds from the start of the replay.
+  const starts = [];
+  let total = 0;
+  for (const f of frames) {
+    starts.push(total);
+    total += f.d;
+  }
+  scrubber.max = total;
+
+  const format = (ms) => {
+    const s = Math.floor(ms / 1000);
+    return Math.floor(s / 60) + ':' + String(s % 60).padStart(2, '0');
+  };
+
+  let now = 0, shown = -1, timer = null, last = 0;
+  const show = () => {
+    // Find the last frame starting at or before now.
+    let lo = 0, hi = starts.length - 1;
+    while (lo < hi) {
+      const mid = (lo + hi + 1) >> 1;
+      if (starts[mid] <= now) { lo = mid; } else { hi = mid - 1; }
+    }
+    if (lo !== shown && frames.length > 0) {
+      screen.innerHTML = frames[lo].h;
+      shown = lo;
+    }
+    scrubber.value = now;
+    timeLabel.textContent = format(now) + ' / ' + format(total);
+  };
+
+  const pause = () => {
+    cancelAnimationFrame(timer);
+    timer = null;
+    play.textContent = 'Play';
+  };
+  const tick = (t) => {
+    now = Math.min(total, now + (t - last));
+    last = t;
+    show();
+    if (now >= total) {
+      pause();
+      return;
+    }
+    timer = requestAnimationFrame(tick);
+  };
+  play.addEventListener('click', () => {
+    if (timer !== null) {
+      pause();
+      return;
+    }
+    if (now >= total) {
+      now = 0;
+    }
+    play.textContent = 'Pause';
+    last = performance.now();
+    timer = requestAnimationFrame(tick);
+  });
+  scrubber.addEventListener('input', () => {
+    now = Number(scrubber.value);
+    show();
+  });
+  show();
+})();
+		</script>
+	</body>
+</html>
+`
+)
+
+// writeReplayHTML writes the replay page for frames.
+func writeReplayHTML(w io.Writer, frames []replayFrame) error {
+	styleSheet, err := assets.TerminalCSS()
+	if err != nil {
+		return err
+	}
+	// encoding/json escapes <, > and &, so the frames can't end the script
+	// element early.
+	data, err := json.Marshal(frames)
+	if err != nil {
+		return err
+	}
+	for _, b := range [][]byte{[]byte(replayPrologue), styleSheet, []byte(replayInterlogue), data, []byte(replayEpilogue)} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
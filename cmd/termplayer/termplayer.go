@@ -1,93 +1,324 @@
 // termplayer outputs the contents of a file "slowly". It "plays back" raw
 // Buildkite job logs as though the job was running in a local terminal.
+//
+// When stdin is a terminal (and the log is read from a file), playback can be
+// controlled from the keyboard: space pauses and resumes, n steps forward to
+// the next timestamp (or line) while paused, and q quits.
 package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"os"
-	"regexp"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/buildkite/terminal-to-html/v3"
 )
 
 var (
 	buildkiteMode = flag.Bool("bk", true, "If the file contains BK metadata, emit output at times corresponding to embedded timestamps instead of at a fixed rate")
 	speed         = flag.Int("speed", 1, "Rate of lines emitted per second. In BK mode, this multiplies the output speed")
+	startAt       = flag.String("start-at", "", "In BK mode, output everything before this log time immediately, and play back from there. Either an RFC 3339 time, or a duration (eg 1m30s or 00:01:30) after the first timestamp")
+	endAt         = flag.String("end-at", "", "In BK mode, stop at this log time, in the same formats as --start-at")
+	maxIdle       = flag.Duration("max-idle", 0, "In BK mode, the longest to wait between timestamps (before applying --speed), to compress long gaps. 0 means no limit")
+	htmlOut       = flag.String("html", "", "Instead of playing back in the terminal, write a self-contained HTML file to this path that replays the log, with a snapshot of the screen at each timestamp. --speed, --max-idle, --start-at and --end-at apply to the replay")
+	htmlCols      = flag.Int("html-cols", 160, "With --html, the width of the replayed terminal")
+	htmlLines     = flag.Int("html-lines", 50, "With --html, the height of the replayed terminal")
 )
 
-var buildkiteRE = regexp.MustCompile(`^_bk;t=(\d+)$`)
+// errQuit is returned when playback is stopped from the keyboard.
+var errQuit = errors.New("quit")
+
+// player plays back a log to out.
+type player struct {
+	out io.Writer
+
+	// Playback options. start and end are zero if unbounded.
+	speed      int
+	maxIdle    time.Duration
+	start, end terminal.TimeBound
+
+	// keys receives keypresses, or is nil if there is no keyboard control.
+	keys   <-chan byte
+	paused bool
+
+	// If set, delay is called instead of waiting between timestamps.
+	delay func(time.Duration) error
+
+	// For testing.
+	now   func() time.Time
+	after func(time.Duration) <-chan time.Time
+
+	// Log time state, in milliseconds since the epoch.
+	hasFirst         bool
+	last             int64
+	startMS, endMS   int64
+	hasStart, hasEnd bool
+}
 
 func main() {
 	flag.Parse()
+	if err := run(); err != nil && !errors.Is(err, errQuit) {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	if *speed <= 0 {
+		return fmt.Errorf("invalid --speed %d: must be positive", *speed)
+	}
+
+	p := &player{
+		out:     os.Stdout,
+		speed:   *speed,
+		maxIdle: *maxIdle,
+		now:     time.Now,
+		after:   time.After,
+	}
+	for _, b := range []struct {
+		flag, value string
+		bound       *terminal.TimeBound
+	}{
+		{"--start-at", *startAt, &p.start},
+		{"--end-at", *endAt, &p.end},
+	} {
+		if b.value == "" {
+			continue
+		}
+		tb, err := terminal.ParseTimeBound(b.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", b.flag, err)
+		}
+		*b.bound = tb
+	}
 
 	input := os.Stdin
 	if len(flag.Args()) > 0 && flag.Arg(0) != "-" {
 		f, err := os.Open(flag.Arg(0))
 		if err != nil {
-			log.Fatalf("Couldn't open file: %v", err)
+			return fmt.Errorf("couldn't open file: %w", err)
 		}
 		defer f.Close()
 		input = f
+
+		// The keyboard is only available if the log isn't coming from stdin.
+		if *htmlOut == "" {
+			if restore, err := cbreak(os.Stdin); err == nil {
+				defer restore()
+				p.keys = readKeys(os.Stdin)
+			}
+		}
 	}
 
 	rd := bufio.NewReader(input)
+	if *htmlOut != "" {
+		return writeReplay(*htmlOut, p, rd)
+	}
 	if *buildkiteMode {
-		buildkiteModeOutput(rd)
-	} else {
-		fixedRateOutput(rd)
+		return p.buildkiteModeOutput(rd)
+	}
+	return p.fixedRateOutput(rd)
+}
+
+// readKeys reads keypresses from f in the background.
+func readKeys(f *os.File) <-chan byte {
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := f.Read(buf); err != nil {
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+	return keys
+}
+
+// wait waits for d, unless interrupted from the keyboard. While paused, it
+// waits until playback is resumed or stepped.
+func (p *player) wait(d time.Duration) error {
+	for {
+		if p.paused {
+			switch <-p.keys {
+			case ' ', 'p':
+				p.paused = false
+			case 'n', '.':
+				// Step: output the next part, then stay paused.
+				return nil
+			case 'q', 3: // 3 is Ctrl-C
+				return errQuit
+			}
+			continue
+		}
+		if d <= 0 {
+			return nil
+		}
+
+		start := p.now()
+		select {
+		case <-p.after(d):
+			return nil
+		case k := <-p.keys:
+			d -= p.now().Sub(start)
+			switch k {
+			case ' ', 'p':
+				p.paused = true
+			case 'n', '.':
+				// Skip the rest of the wait.
+				return nil
+			case 'q', 3:
+				return errQuit
+			}
+		}
 	}
 }
 
-func buildkiteModeOutput(rd *bufio.Reader) {
-	var lastTS int
+// buildkiteModeOutput plays back the log at times corresponding to its
+// Buildkite timestamps.
+func (p *player) buildkiteModeOutput(rd *bufio.Reader) error {
 	for {
 		chunk, err := rd.ReadBytes(0x1b)
-		os.Stdout.Write(chunk)
 		if err == io.EOF {
-			return
+			p.out.Write(chunk)
+			return nil
 		}
 		if err != nil {
-			log.Fatalf("Reading byte from input: %v\n", err)
+			return fmt.Errorf("reading byte from input: %w", err)
 		}
-		code, err := rd.Peek(20)
-		if err == bufio.ErrBufferFull || err == io.EOF {
-			continue
+		// Hold back the ESC until it's known whether it starts an APC that
+		// ends playback.
+		p.out.Write(chunk[:len(chunk)-1])
+
+		// Is this an APC?
+		next, err := rd.Peek(1)
+		if err == io.EOF {
+			p.out.Write(chunk[len(chunk)-1:])
+			return nil
 		}
 		if err != nil {
-			log.Fatalf("Peeking 20 bytes from input: %v\n", err)
+			return fmt.Errorf("peeking byte from input: %w", err)
 		}
-		matches := buildkiteRE.FindSubmatch(code)
-		if matches == nil {
+		if next[0] != '_' {
+			p.out.Write(chunk[len(chunk)-1:])
 			continue
 		}
-		ts, err := strconv.Atoi(string(matches[1]))
+		apc, err := readAPC(rd)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("reading APC from input: %w", err)
+		}
+
+		if ts, ok := p.timestamp(apc); ok {
+			if err := p.at(ts); err != nil {
+				if errors.Is(err, errEnd) {
+					return nil
+				}
+				return err
+			}
+		}
+		p.out.Write(chunk[len(chunk)-1:])
+		p.out.Write(apc)
+	}
+}
+
+// readAPC reads an APC sequence (following an ESC that has already been
+// read), up to and including the BEL or ST that terminates it.
+func readAPC(rd *bufio.Reader) ([]byte, error) {
+	var apc []byte
+	for {
+		b, err := rd.ReadByte()
 		if err != nil {
-			log.Fatalf("Converting string to int: %v", err)
+			return apc, err
 		}
-		if lastTS == 0 {
-			lastTS = ts
-			continue
+		apc = append(apc, b)
+		if b == '\x07' || (b == '\\' && len(apc) >= 2 && apc[len(apc)-2] == 0x1b) {
+			return apc, nil
 		}
-		dt := time.Duration(ts-lastTS) * time.Millisecond
-		lastTS = ts
-		if dt > 0 {
-			time.Sleep(dt / time.Duration(*speed))
+	}
+}
+
+// timestamp returns the log time (milliseconds since the epoch) set by an
+// APC sequence (as read by readAPC), if it's a Buildkite timestamp. Like the
+// terminal-to-html renderer, dt is relative to the previous timestamp.
+func (p *player) timestamp(apc []byte) (int64, bool) {
+	payload := strings.TrimPrefix(string(apc), "_bk;")
+	if len(payload) == len(apc) {
+		return 0, false
+	}
+	payload = strings.TrimSuffix(strings.TrimSuffix(payload, "\x07"), "\x1b\\")
+	data, err := terminal.KeyValueAPCHandler(payload)
+	if err != nil {
+		return 0, false
+	}
+
+	ts, ok := p.last, false
+	if t, err := strconv.ParseInt(data["t"], 10, 64); err == nil {
+		ts, ok = t, true
+	}
+	if dt, err := strconv.ParseInt(data["dt"], 10, 64); err == nil {
+		ts, ok = ts+dt, true
+	}
+	return ts, ok
+}
+
+// errEnd is returned by at when the end of playback is reached.
+var errEnd = errors.New("reached --end-at")
+
+// at waits until it's time to output what follows a timestamp.
+func (p *player) at(ts int64) error {
+	if !p.hasFirst {
+		p.hasFirst, p.last = true, ts
+		first := time.UnixMilli(ts)
+		if t, ok := p.start.Time(first); ok {
+			p.startMS, p.hasStart = t.UnixMilli(), true
 		}
+		if t, ok := p.end.Time(first); ok {
+			p.endMS, p.hasEnd = t.UnixMilli(), true
+		}
+	}
+
+	if p.hasEnd && ts > p.endMS {
+		return errEnd
+	}
+
+	// Before --start-at, output as fast as possible. Playing back from
+	// --start-at begins without a wait.
+	last := p.last
+	p.last = ts
+	if p.hasStart && last < p.startMS {
+		return nil
+	}
+
+	gap := time.Duration(ts-last) * time.Millisecond
+	if p.maxIdle > 0 {
+		gap = min(gap, p.maxIdle)
+	}
+	gap /= time.Duration(p.speed)
+	if p.delay != nil {
+		return p.delay(gap)
 	}
+	return p.wait(gap)
 }
 
-func fixedRateOutput(rd *bufio.Reader) {
-	for range time.Tick(time.Second / time.Duration(*speed)) {
+// fixedRateOutput plays back the log at a fixed number of lines per second.
+func (p *player) fixedRateOutput(rd *bufio.Reader) error {
+	for {
 		line, err := rd.ReadBytes('\n')
-		os.Stdout.Write(line)
+		p.out.Write(line)
 		if err == io.EOF {
-			return
+			return nil
 		}
 		if err != nil {
-			log.Fatalf("Reading bytes from input: %v\n", err)
+			return fmt.Errorf("reading bytes from input: %w", err)
+		}
+		if err := p.wait(time.Second / time.Duration(p.speed)); err != nil {
+			return err
 		}
 	}
 }
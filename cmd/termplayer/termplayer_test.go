@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildkite/terminal-to-html/v3"
+	"github.com/google/go-cmp/cmp"
+)
+
+// newTestPlayer returns a player that records waits instead of waiting.
+func newTestPlayer(out *strings.Builder, waits *[]time.Duration) *player {
+	return &player{
+		out:   out,
+		speed: 1,
+		now:   func() time.Time { return time.Time{} },
+		after: func(d time.Duration) <-chan time.Time {
+			*waits = append(*waits, d)
+			ch := make(chan time.Time, 1)
+			ch <- time.Time{}
+			return ch
+		},
+	}
+}
+
+func TestBuildkiteModeOutput(t *testing.T) {
+	input := strings.Join([]string{
+		"\x1b_bk;t=1000\x07one",
+		"\x1b_bk;dt=2000\x07two",         // 3s
+		"\x1b_bk;t=63000\x1b\\three",     // 63s
+		"\x1b[31mred\x1b[0m",             // not an APC
+		"\x1b_bk;t=64000;dt=500\x07four", // 64.5s
+		"\x1b_other;t=99999\x07five",
+	}, "\n")
+
+	tests := []struct {
+		name      string
+		setup     func(*player)
+		wantWaits []time.Duration
+		wantOut   string
+	}{
+		{
+			name:      "real time",
+			wantWaits: []time.Duration{2 * time.Second, 60 * time.Second, 1500 * time.Millisecond},
+			wantOut:   input,
+		},
+		{
+			name:      "speed and max idle",
+			setup:     func(p *player) { p.speed, p.maxIdle = 2, 10*time.Second },
+			wantWaits: []time.Duration{time.Second, 5 * time.Second, 750 * time.Millisecond},
+			wantOut:   input,
+		},
+		{
+			name:      "start at",
+			setup:     func(p *player) { p.start = terminal.RelativeTime(time.Minute) },
+			wantWaits: []time.Duration{1500 * time.Millisecond},
+			wantOut:   input,
+		},
+		{
+			name:      "end at",
+			setup:     func(p *player) { p.end = terminal.AbsoluteTime(time.UnixMilli(3000)) },
+			wantWaits: []time.Duration{2 * time.Second},
+			wantOut:   "\x1b_bk;t=1000\x07one\n\x1b_bk;dt=2000\x07two\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out strings.Builder
+			var waits []time.Duration
+			p := newTestPlayer(&out, &waits)
+			if test.setup != nil {
+				test.setup(p)
+			}
+			if err := p.buildkiteModeOutput(bufio.NewReader(strings.NewReader(input))); err != nil {
+				t.Fatalf("buildkiteModeOutput error = %v", err)
+			}
+			if diff := cmp.Diff(waits, test.wantWaits); diff != "" {
+				t.Errorf("waits diff (-got +want):\n%s", diff)
+			}
+			if diff := cmp.Diff(out.String(), test.wantOut); diff != "" {
+				t.Errorf("output diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWaitKeys(t *testing.T) {
+	keys := make(chan byte, 3)
+	never := make(chan time.Time)
+	p := &player{
+		keys:  keys,
+		now:   func() time.Time { return time.Time{} },
+		after: func(time.Duration) <-chan time.Time { return never },
+	}
+
+	// Pause, then step: wait returns, still paused.
+	keys <- ' '
+	keys <- 'n'
+	if err := p.wait(time.Hour); err != nil {
+		t.Fatalf("wait error = %v", err)
+	}
+	if !p.paused {
+		t.Errorf("after stepping, paused = false, want true")
+	}
+
+	// Quit while paused.
+	keys <- 'q'
+	if err := p.wait(time.Hour); err != errQuit {
+		t.Errorf("wait error = %v, want %v", err, errQuit)
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	input := strings.Join([]string{
+		"\x1b_bk;t=1000\x07one",
+		"\x1b_bk;t=2000\x07two\r\x1b_bk;t=2500\x07TWO",
+		"\x1b_bk;t=4000\x07three",
+		"\x1b_bk;t=4000\x07four",
+	}, "\n")
+
+	var out strings.Builder
+	var waits []time.Duration
+	p := newTestPlayer(&out, &waits)
+	got, err := recordReplay(p, bufio.NewReader(strings.NewReader(input)), 80, 3)
+	if err != nil {
+		t.Fatalf("recordReplay() error = %v", err)
+	}
+	want := []replayFrame{
+		{Duration: 1000, HTML: "one"},
+		{Duration: 500, HTML: "one\ntwo"},
+		{Duration: 1500, HTML: "one\nTWO"},
+		{Duration: 0, HTML: "TWO\nthree\nfour"},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("recordReplay() diff (-got +want):\n%s", diff)
+	}
+	if len(waits) != 0 || out.Len() != 0 {
+		t.Errorf("recordReplay() waited %v and wrote %q, want no waits or output", waits, out.String())
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// cbreak reports a "not implemented" error.
+func cbreak(*os.File) (func(), error) {
+	return nil, fmt.Errorf("not implemented for %s", runtime.GOOS)
+}
@@ -0,0 +1,31 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cbreak puts the terminal f into a mode where each keypress can be read as
+// soon as it's typed, without being echoed. It returns a func that restores
+// the previous mode, or an error if f isn't a terminal.
+func cbreak(f *os.File) (restore func(), err error) {
+	fd := int(f.Fd())
+	old, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return nil, err
+	}
+
+	t := *old
+	// ISIG is turned off too, so that Ctrl-C can be handled as a keypress
+	// and the terminal restored.
+	t.Lflag &^= unix.ICANON | unix.ECHO | unix.ISIG
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, ioctlSetTermios, &t); err != nil {
+		return nil, err
+	}
+	return func() { unix.IoctlSetTermios(fd, ioctlSetTermios, old) }, nil
+}
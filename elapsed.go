@@ -0,0 +1,124 @@
+package terminal
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// noGap is the gap for a line when the time until the next line isn't known.
+const noGap = time.Duration(-1)
+
+// WithElapsed controls showing how long each line took: the time between its
+// Buildkite timestamp and the timestamp of the following line. In HTML this
+// is a <span class="term-elapsed"> after the timestamp, and in plain text a
+// Δ column.
+//
+// While elapsed times (or slow line highlighting) are in use, each line
+// scrolled out of the buffer is held back until the next line scrolls out,
+// since its elapsed time depends on the next line. AsHTML and the other
+// rendering methods include the held line before the buffer.
+func WithElapsed(enabled bool) ScreenOption {
+	return func(s *Screen) error {
+		s.elapsed = enabled
+		return nil
+	}
+}
+
+// WithSlowThreshold highlights lines that took threshold or longer (see
+// WithElapsed). In HTML, slow lines are wrapped in a
+// <span class="term-line term-slow"> element, and in plain text the Δ column
+// is shown, with a ! after the elapsed time of slow lines. 0 turns off
+// highlighting.
+func WithSlowThreshold(threshold time.Duration) ScreenOption {
+	return func(s *Screen) error {
+		if threshold < 0 {
+			return fmt.Errorf("negative slow threshold %v", threshold)
+		}
+		s.slowThreshold = threshold
+		return nil
+	}
+}
+
+// lineGaps reports whether elapsed times are needed for rendering.
+func (s *Screen) lineGaps() bool {
+	return s.elapsed || s.slowThreshold > 0
+}
+
+// lineGap returns the time between the timestamps of a line and the next
+// line, or noGap if either doesn't have one.
+func lineGap(parts, next []screenLine) time.Duration {
+	t, ok := lineTimestamp(parts)
+	if !ok {
+		return noGap
+	}
+	nextT, ok := lineTimestamp(next)
+	if !ok {
+		return noGap
+	}
+	return time.Duration(nextT-t) * time.Millisecond
+}
+
+// slow reports whether a line with the gap should be highlighted.
+func (s *Screen) slow(gap time.Duration) bool {
+	return s.slowThreshold > 0 && gap >= s.slowThreshold
+}
+
+// formatGap formats the time a line took for display.
+func formatGap(gap time.Duration) string {
+	return gap.Round(time.Millisecond).String()
+}
+
+// plainGapColumnWidth is the width of the Δ column in plain text, excluding
+// the Δ. It fits durations up to 99m59.999s, and the slow marker.
+const plainGapColumnWidth = 11
+
+// plainGapColumn returns the Δ column for plain text output, or "" if it isn't
+// shown.
+func (s *Screen) plainGapColumn(gap time.Duration) string {
+	if !s.lineGaps() {
+		return ""
+	}
+	text := ""
+	if gap >= 0 {
+		text = formatGap(gap)
+		if s.slow(gap) {
+			text += "!"
+		}
+	}
+	return fmt.Sprintf("Δ%-*s", plainGapColumnWidth, text)
+}
+
+// holdLine holds back a line scrolled out of the buffer until the next line
+// scrolls out (or is rendered) and its elapsed time is known. It returns the
+// previously held line, if any. The line's nodes are copied, because the
+// originals are recycled for new lines.
+func (s *Screen) holdLine(parts []screenLine) []screenLine {
+	held := s.held
+	s.held = make([]screenLine, len(parts))
+	for i, l := range parts {
+		l.nodes = slices.Clone(l.nodes)
+		s.held[i] = l
+	}
+	return held
+}
+
+// gaps returns the elapsed time for each of lines (all noGap if they aren't
+// needed).
+func (s *Screen) gaps(lines [][]screenLine) []time.Duration {
+	gaps := make([]time.Duration, len(lines))
+	for i := range lines {
+		gaps[i] = noGap
+		if s.lineGaps() && i+1 < len(lines) {
+			gaps[i] = lineGap(lines[i], lines[i+1])
+		}
+	}
+	return gaps
+}
+
+// appendGap appends the elapsed time element for HTML output.
+func (b *outputBuffer) appendGap(gap time.Duration) {
+	b.WriteString(`<span class="term-elapsed">`)
+	b.WriteString(formatGap(gap))
+	b.WriteString(`</span>`)
+}
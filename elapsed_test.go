@@ -0,0 +1,118 @@
+package terminal
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// Lines taking 1.5s, 6s, and unknown (the last line).
+const elapsedTestInput = "\x1b_bk;t=1000\x07one\n\x1b_bk;t=2500\x07two\n\x1b_bk;t=8500\x07three"
+
+func TestElapsed(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []ScreenOption
+		wantHTML  string
+		wantPlain string
+	}{
+		{
+			name: "elapsed",
+			opts: []ScreenOption{WithElapsed(true)},
+			wantHTML: strings.Join([]string{
+				`<time datetime="1970-01-01T00:00:01Z">1970-01-01T00:00:01Z</time><span class="term-elapsed">1.5s</span>one`,
+				`<time datetime="1970-01-01T00:00:02.5Z">1970-01-01T00:00:02.5Z</time><span class="term-elapsed">6s</span>two`,
+				`<time datetime="1970-01-01T00:00:08.5Z">1970-01-01T00:00:08.5Z</time>three`,
+			}, "\n"),
+			wantPlain: strings.Join([]string{
+				"1970-01-01T00:00:01Z Δ1.5s        one",
+				"1970-01-01T00:00:02Z Δ6s          two",
+				"1970-01-01T00:00:08Z Δ            three",
+			}, "\n"),
+		},
+		{
+			name: "slow",
+			opts: []ScreenOption{WithSlowThreshold(5 * time.Second)},
+			wantHTML: strings.Join([]string{
+				`<time datetime="1970-01-01T00:00:01Z">1970-01-01T00:00:01Z</time>one`,
+				`<span class="term-line term-slow"><time datetime="1970-01-01T00:00:02.5Z">1970-01-01T00:00:02.5Z</time>two</span>`,
+				`<time datetime="1970-01-01T00:00:08.5Z">1970-01-01T00:00:08.5Z</time>three`,
+			}, "\n"),
+			wantPlain: strings.Join([]string{
+				"1970-01-01T00:00:01Z Δ1.5s        one",
+				"1970-01-01T00:00:02Z Δ6s!         two",
+				"1970-01-01T00:00:08Z Δ            three",
+			}, "\n"),
+		},
+		{
+			name: "slow with metadata attributes and groups",
+			opts: []ScreenOption{
+				WithSlowThreshold(time.Second),
+				WithElapsed(true),
+				WithMetadataAttributes("*"),
+				WithGroups(true),
+			},
+			wantHTML: strings.Join([]string{
+				`<span class="term-line term-slow"><time datetime="1970-01-01T00:00:01Z">1970-01-01T00:00:01Z</time><span class="term-elapsed">1.5s</span>one</span>`,
+				`<span class="term-line term-slow"><time datetime="1970-01-01T00:00:02.5Z">1970-01-01T00:00:02.5Z</time><span class="term-elapsed">6s</span>two</span>`,
+				`<time datetime="1970-01-01T00:00:08.5Z">1970-01-01T00:00:08.5Z</time>three`,
+			}, "\n"),
+			wantPlain: strings.Join([]string{
+				"1970-01-01T00:00:01Z Δ1.5s!       one",
+				"1970-01-01T00:00:02Z Δ6s!         two",
+				"1970-01-01T00:00:08Z Δ            three",
+			}, "\n"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(test.opts...)
+			if err != nil {
+				t.Fatalf("NewScreen(...) error = %v", err)
+			}
+			s.Write([]byte(elapsedTestInput))
+			if diff := cmp.Diff(s.AsHTML(), test.wantHTML); diff != "" {
+				t.Errorf("AsHTML() diff (-got +want):\n%s", diff)
+			}
+			if diff := cmp.Diff(s.AsPlainTextWithTimestamps(true), test.wantPlain); diff != "" {
+				t.Errorf("AsPlainTextWithTimestamps(true) diff (-got +want):\n%s", diff)
+			}
+
+			// Streaming output should be the same, however small the buffer.
+			for _, maxLines := range []int{1, 2} {
+				s, err := NewScreen(append(test.opts, WithMaxSize(0, maxLines))...)
+				if err != nil {
+					t.Fatalf("NewScreen(..., WithMaxSize(0, %d)) error = %v", maxLines, err)
+				}
+				var html strings.Builder
+				s.ScrollOutFunc = func(line string) { html.WriteString(line) }
+				s.Write([]byte(elapsedTestInput))
+				html.WriteString(s.AsHTML())
+				if diff := cmp.Diff(html.String(), test.wantHTML); diff != "" {
+					t.Errorf("with max lines %d, streamed HTML diff (-got +want):\n%s", maxLines, diff)
+				}
+
+				s, err = NewScreen(append(test.opts, WithMaxSize(0, maxLines))...)
+				if err != nil {
+					t.Fatalf("NewScreen(..., WithMaxSize(0, %d)) error = %v", maxLines, err)
+				}
+				var plain strings.Builder
+				s.ScrollOutPlainFunc = func(line string) { plain.WriteString(line) }
+				s.Write([]byte(elapsedTestInput))
+				plain.WriteString(s.AsPlainTextWithTimestamps(true))
+				if diff := cmp.Diff(plain.String(), test.wantPlain); diff != "" {
+					t.Errorf("with max lines %d, streamed plain text diff (-got +want):\n%s", maxLines, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestSlowThresholdNegative(t *testing.T) {
+	if _, err := NewScreen(WithSlowThreshold(-time.Second)); err == nil {
+		t.Errorf("NewScreen(WithSlowThreshold(-1s)) error = nil, want error")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"html"
 	"mime"
+	"strconv"
 	"strings"
 )
 
@@ -14,6 +15,11 @@ const (
 	elementITermLink
 	elementImage
 	elementLink
+	elementITermFile
+	elementBlock
+	elementCallout
+	elementArtifact
+	elementBadge
 )
 
 type element struct {
@@ -24,11 +30,36 @@ type element struct {
 	height      string
 	width       string
 	elementType int
+
+	// For Buildkite blocks, callouts, artifacts and badges: the title (of a
+	// block or callout), the style (of a callout or badge), whether a block
+	// is expanded, and the artifact path.
+	title string
+	style string
+	open  bool
+	path  string
+
+	// preserveAspectRatio is true if an image should keep its aspect ratio,
+	// fitting within its width and height, rather than filling them.
+	preserveAspectRatio bool
+
+	// linkID is the id parameter of an OSC 8 link. Parts of the output linked
+	// with the same URL and id are one link.
+	linkID string
+
+	// assetURL is the URL of an inline image stored in an asset sink (see
+	// WithAssetSink), once it has been stored. assetErr is set instead if
+	// storing it failed, so that it's only tried (and reported) once.
+	assetURL string
+	assetErr error
 }
 
 var errUnsupportedElementSequence = errors.New("Unsupported element sequence")
 
-func (i *element) asHTML() string {
+// asHTML renders the element, with URLs checked against the screen's URL
+// policy, link attributes from its link attribute policy, and images checked
+// against its image policy.
+func (i *element) asHTML(s *Screen) string {
 	h := html.EscapeString
 
 	if i.elementType == elementLink {
@@ -36,7 +67,18 @@ func (i *element) asHTML() string {
 		if content == "" {
 			content = i.url
 		}
-		return fmt.Sprintf(`<a href="%s">%s</a>`, h(sanitizeURL(i.url)), h(content))
+		var buf outputBuffer
+		buf.appendAnchor(s, i.url, "")
+		buf.WriteString(h(content))
+		buf.closeAnchor()
+		return buf.String()
+	}
+
+	switch i.elementType {
+	case elementITermFile:
+		return i.attachmentHTML(s)
+	case elementBlock, elementCallout, elementArtifact, elementBadge:
+		return i.buildkiteHTML(s)
 	}
 
 	alt := i.alt
@@ -48,11 +90,22 @@ func (i *element) asHTML() string {
 
 	switch i.elementType {
 	case elementITermImage:
+		if reason := s.imagePolicy.check(i.contentType, i.content); reason != "" {
+			return placeholder("image", alt, reason)
+		}
+		if s.assetSink != nil {
+			url, err := s.assetURL(i)
+			if err != nil {
+				return placeholder("image", alt, "couldn't store image")
+			}
+			parts = append(parts, fmt.Sprintf(`src="%s"`, h(url)))
+			break
+		}
 		src := fmt.Sprintf(`src="data:%s;base64,%s"`, h(i.contentType), h(i.content))
 		parts = append(parts, src)
 
 	case elementImage:
-		url := sanitizeURL(i.url)
+		url := s.urlPolicy.sanitize(i.url)
 		if url == "" || url == unsafeURLSubstitution {
 			// don't emit an <img> at all if the URL is empty or didn't sanitize
 			return ""
@@ -71,6 +124,12 @@ func (i *element) asHTML() string {
 	if i.height != "" {
 		parts = append(parts, fmt.Sprintf(`height="%s"`, h(i.height)))
 	}
+	if i.preserveAspectRatio && i.width != "" && i.height != "" {
+		parts = append(parts, `style="object-fit: contain"`)
+	}
+	if s.imagePolicy.Lazy {
+		parts = append(parts, `loading="lazy"`)
+	}
 
 	return fmt.Sprintf(`<img %s>`, strings.Join(parts, " "))
 }
@@ -79,8 +138,13 @@ func parseElementSequence(sequence string) (*element, error) {
 	// Expect:
 	// - iTerm style hyperlink:    8;id=1234;http://example.com/
 	// - iTerm style inline image: 1337;File=name=1.gif;inline=1:BASE64
+	// - iTerm style file:         1337;File=name=1.pdf;size=1234:BASE64
 	// - Buildkite external image: 1338;url=…;alt=…;width=…;height=…
 	// - Buildkite hyperlink:      1339;url=…;content=…
+	// - Buildkite block:          1340;title=…;content=…;open=1
+	// - Buildkite callout:        1341;style=warning;title=…;content=…
+	// - Buildkite artifact:       1342;path=…;content=…
+	// - Buildkite badge:          1343;style=info;content=…
 
 	args, elementType, content, err := splitAndVerifyElementSequence(sequence)
 	if err != nil {
@@ -92,9 +156,13 @@ func parseElementSequence(sequence string) (*element, error) {
 
 	elem := &element{content: content, elementType: elementType}
 
+	// By default, iTerm keeps the aspect ratio of images.
+	elem.preserveAspectRatio = elementType == elementITermImage
+
 	if elementType == elementITermLink {
 		// For "iTerm" links (OSC 8), tokens[0] is params and tokens[1] is the URL.
-		// Aside from not quoting the URL, we ignore params.
+		// Aside from not quoting the URL, the params are colon-separated
+		// key=value pairs, of which only id is used.
 		// The link "content" comes after the element and is stored
 		// as regular text in the screen line, because they are designed to gracefully
 		// degrade to plain text if the sequence isn't supported.
@@ -104,6 +172,11 @@ func parseElementSequence(sequence string) (*element, error) {
 			return nil, nil
 		}
 		elem.url = tokens[1]
+		for _, param := range strings.Split(tokens[0], ":") {
+			if id, ok := strings.CutPrefix(param, "id="); ok {
+				elem.linkID = id
+			}
+		}
 		return elem, nil
 	}
 
@@ -113,6 +186,7 @@ func parseElementSequence(sequence string) (*element, error) {
 	}
 
 	imageInline := false
+	size := -1
 
 	for _, token := range tokens {
 		parts := strings.SplitN(token, "=", 2)
@@ -135,12 +209,28 @@ func parseElementSequence(sequence string) (*element, error) {
 			elem.content = val
 		case "inline":
 			imageInline = val == "1"
+		case "size":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("size= value of %q is not a valid size", val)
+			}
+			size = n
+		case "preserveaspectratio":
+			elem.preserveAspectRatio = val != "0"
 		case "width":
 			elem.width = parseImageDimension(val)
 		case "height":
 			elem.height = parseImageDimension(val)
 		case "alt":
 			elem.alt = val
+		case "title":
+			elem.title = val
+		case "style":
+			elem.style = val
+		case "open":
+			elem.open = val == "1"
+		case "path":
+			elem.path = val
 		}
 	}
 
@@ -148,19 +238,28 @@ func parseElementSequence(sequence string) (*element, error) {
 		if elem.url == "" {
 			return nil, fmt.Errorf("name= argument not supplied, required to determine content type")
 		}
+		if size >= 0 && decodedLen(elem.content) != size {
+			return nil, fmt.Errorf("size= argument is %d, but the content is %d bytes", size, decodedLen(elem.content))
+		}
+		if !imageInline {
+			// In iTerm2, if you don't specify inline=1, the file is merely
+			// downloaded and not displayed, so it's rendered as a link to
+			// download it.
+			elem.elementType = elementITermFile
+			if elem.contentType == "" {
+				elem.contentType = "application/octet-stream"
+			}
+			return elem, nil
+		}
 		if elem.contentType == "" {
 			return nil, fmt.Errorf("can't determine content type for %q", elem.url)
 		}
-	} else {
+	} else if elem.elementType == elementImage || elem.elementType == elementLink {
 		if elem.url == "" {
 			return nil, fmt.Errorf("url= argument not supplied")
 		}
-	}
-
-	if elem.elementType == elementITermImage && !imageInline {
-		// in iTerm2, if you don't specify inline=1, the image is merely downloaded
-		// and not displayed.
-		elem = nil
+	} else if err := elem.validateBuildkite(); err != nil {
+		return nil, err
 	}
 	return elem, nil
 }
@@ -192,6 +291,18 @@ func splitAndVerifyElementSequence(s string) (arguments string, elementType int,
 	if rem, has := strings.CutPrefix(s, "1339;"); has {
 		return rem, elementLink, "", nil
 	}
+	if rem, has := strings.CutPrefix(s, "1340;"); has {
+		return rem, elementBlock, "", nil
+	}
+	if rem, has := strings.CutPrefix(s, "1341;"); has {
+		return rem, elementCallout, "", nil
+	}
+	if rem, has := strings.CutPrefix(s, "1342;"); has {
+		return rem, elementArtifact, "", nil
+	}
+	if rem, has := strings.CutPrefix(s, "1343;"); has {
+		return rem, elementBadge, "", nil
+	}
 
 	rem, has := strings.CutPrefix(s, "1337;File=")
 	if !has {
@@ -2,6 +2,7 @@ package terminal
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -38,8 +39,16 @@ var errorCases = []struct {
 		`invalid syntax: unclosed quotation marks`,
 	}, {
 		`1337: can't determine content type`,
-		"1337;File=name=" + base64Encode("foo.baz") + ":AA==",
+		"1337;File=name=" + base64Encode("foo.baz") + ";inline=1:AA==",
 		`can't determine content type for "foo.baz"`,
+	}, {
+		`1337: size doesn't match content`,
+		"1337;File=name=" + base64Encode("foo.gif") + ";size=2;inline=1:AA==",
+		`size= argument is 2, but the content is 1 bytes`,
+	}, {
+		`1337: invalid size`,
+		"1337;File=name=" + base64Encode("foo.gif") + ";size=big;inline=1:AA==",
+		`size= value of "big" is not a valid size`,
 	}, {
 		`1337: no image content`,
 		"1337;File=name=foo.jpg:",
@@ -48,6 +57,38 @@ var errorCases = []struct {
 		`1338: url missing`,
 		"1338;",
 		`url= argument not supplied`,
+	}, {
+		`1340: title missing`,
+		"1340;content=hello",
+		`title= argument not supplied`,
+	}, {
+		`1341: content missing`,
+		"1341;style=info",
+		`content= argument not supplied`,
+	}, {
+		`1341: unknown style`,
+		"1341;style=loud;content=hello",
+		`style= value of "loud" is not one of info, success, warning, error`,
+	}, {
+		`1342: path missing`,
+		"1342;content=hello",
+		`path= argument not supplied`,
+	}, {
+		`1342: path escapes the artifacts`,
+		"1342;path=a/../../secret",
+		`path= value of "a/../../secret" must not contain .. segments`,
+	}, {
+		`1342: absolute path`,
+		"1342;path=/etc/passwd",
+		`path= value of "/etc/passwd" must be relative`,
+	}, {
+		`1342: path with a host`,
+		"1342;path=//evil.example.com/x",
+		`path= value of "//evil.example.com/x" must be relative`,
+	}, {
+		`1343: content missing`,
+		"1343;",
+		`content= argument not supplied`,
 	},
 }
 
@@ -74,34 +115,50 @@ var validCases = []struct {
 		`unsupported escape sequence`,
 		"9999",
 		nil,
+	}, {
+		`8: link`,
+		"8;;https://example.org/",
+		&element{url: "https://example.org/", elementType: elementITermLink},
+	}, {
+		`8: link with id`,
+		"8;foo=bar:id=42;https://example.org/",
+		&element{url: "https://example.org/", linkID: "42", elementType: elementITermLink},
 	}, {
 		`1337: image with name, content & inline`,
 		`1337;File=name=Zm9vLmdpZg==;inline=1:AA==`,
-		&element{url: "foo.gif", content: "AA==", contentType: "image/gif", elementType: elementITermImage},
+		&element{url: "foo.gif", content: "AA==", contentType: "image/gif", preserveAspectRatio: true, elementType: elementITermImage},
 	}, {
-		`1337: image without inline=1 does not render`,
-		`1337;File=name=Zm9vLmdpZg==:AA==`,
-		nil,
+		`1337: file without inline=1 is an attachment`,
+		`1337;File=name=Zm9vLmdpZg==;size=1:AA==`,
+		&element{url: "foo.gif", content: "AA==", contentType: "image/gif", preserveAspectRatio: true, elementType: elementITermFile},
+	}, {
+		`1337: attachment with unknown content type`,
+		"1337;File=name=" + base64Encode("foo.baz") + ":AA==",
+		&element{url: "foo.baz", content: "AA==", contentType: "application/octet-stream", preserveAspectRatio: true, elementType: elementITermFile},
+	}, {
+		`1337: image that doesn't preserve aspect ratio`,
+		`1337;File=name=Zm9vLmdpZg==;width=1;height=5;preserveAspectRatio=0;inline=1:AA==`,
+		&element{url: "foo.gif", content: "AA==", contentType: "image/gif", width: "1em", height: "5em", elementType: elementITermImage},
 	}, {
 		`1337: adapts content type based on image name`,
 		`1337;File=name=` + base64Encode("foo.jpg") + `;inline=1:AA==`,
-		&element{url: "foo.jpg", content: "AA==", contentType: "image/jpeg", elementType: elementITermImage},
+		&element{url: "foo.jpg", content: "AA==", contentType: "image/jpeg", preserveAspectRatio: true, elementType: elementITermImage},
 	}, {
 		`1337: handles width & height`,
 		`1337;File=name=Zm9vLmdpZg==;width=100%;height=50px;inline=1:AA==`,
-		&element{url: "foo.gif", content: "AA==", contentType: "image/gif", width: "100%", height: "50px", elementType: elementITermImage},
+		&element{url: "foo.gif", content: "AA==", contentType: "image/gif", width: "100%", height: "50px", preserveAspectRatio: true, elementType: elementITermImage},
 	}, {
 		`1337: parsing is NOT concerned with XSS in image name, width & height by stripping brackets, because that's protected at render time`,
 		`1337;File=name=` + base64Encode(`foo".gif`) + `;width="100%";height='50px'>;inline=1:AA==`,
-		&element{url: `foo".gif`, content: "AA==", contentType: "image/gif", width: "100%", height: "50px>em", elementType: elementITermImage},
+		&element{url: `foo".gif`, content: "AA==", contentType: "image/gif", width: "100%", height: "50px>em", preserveAspectRatio: true, elementType: elementITermImage},
 	}, {
 		`1337: converts width & height without percent or px to em`,
 		`1337;File=name=Zm9vLmdpZg==;width=1;height=5;inline=1:AA==`,
-		&element{url: "foo.gif", content: "AA==", contentType: "image/gif", width: "1em", height: "5em", elementType: elementITermImage},
+		&element{url: "foo.gif", content: "AA==", contentType: "image/gif", width: "1em", height: "5em", preserveAspectRatio: true, elementType: elementITermImage},
 	}, {
 		`1337: malfored arguments are silently ignored`,
 		`1337;File=name=Zm9vLmdpZg==;inline=1;sdfsdfs;====ddd;herp=derps:AA==`,
-		&element{url: "foo.gif", content: "AA==", contentType: "image/gif", elementType: elementITermImage},
+		&element{url: "foo.gif", content: "AA==", contentType: "image/gif", preserveAspectRatio: true, elementType: elementITermImage},
 	}, {
 		`1338: image with filename`,
 		"1338;url=tmp/foo.gif",
@@ -149,6 +206,22 @@ var validCases = []struct {
 			width:       "<world%>em",
 			elementType: elementLink,
 		},
+	}, {
+		`1340: block`,
+		"1340;title='Build output';content=3 warnings;open=1",
+		&element{title: "Build output", content: "3 warnings", open: true, elementType: elementBlock},
+	}, {
+		`1341: callout with style and title`,
+		"1341;style=warning;title=Heads up;content=Flaky test retried",
+		&element{style: "warning", title: "Heads up", content: "Flaky test retried", elementType: elementCallout},
+	}, {
+		`1342: artifact`,
+		"1342;path=coverage/index.html;content=Coverage",
+		&element{path: "coverage/index.html", content: "Coverage", elementType: elementArtifact},
+	}, {
+		`1343: badge`,
+		"1343;style=success;content=passed",
+		&element{style: "success", content: "passed", elementType: elementBadge},
 	},
 }
 
@@ -222,10 +295,32 @@ var asHTMLCases = []struct {
 func TestAsHTMLCases(t *testing.T) {
 	for _, c := range asHTMLCases {
 		t.Run(c.name, func(t *testing.T) {
-			html := c.element.asHTML()
+			html := c.element.asHTML(&Screen{})
 			if diff := cmp.Diff(html, c.expected); diff != "" {
 				t.Errorf("%v.asHTML() diff (-got +want):\n%s", c.element, diff)
 			}
 		})
 	}
 }
+
+func TestLinkIDs(t *testing.T) {
+	input := strings.Join([]string{
+		"\x1b]8;id=a;https://example.org/\x1b\\first \x1b[1mhalf\x1b[0m",
+		"second half\x1b]8;;\x1b\\ \x1b]8;id=b:x=y;https://example.org/\x1b\\other\x1b]8;;\x1b\\",
+		"\x1b]8;;https://example.org/\x1b\\no id\x1b]8;;\x1b\\",
+	}, "\n")
+	want := strings.Join([]string{
+		`<a href="https://example.org/" data-link-id="a">first <span class="term-fg1">half</span></a>`,
+		`<a href="https://example.org/" data-link-id="a">second half</a> <a href="https://example.org/" data-link-id="b">other</a>`,
+		`<a href="https://example.org/">no id</a>`,
+	}, "\n")
+
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() error = %v", err)
+	}
+	s.Write([]byte(input))
+	if diff := cmp.Diff(s.AsHTML(), want); diff != "" {
+		t.Errorf("AsHTML() diff (-got +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,349 @@
+package terminal
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Buildkite log group headers. A line beginning with one of these markers
+// starts a new group (a collapsible section of the log), which lasts until
+// the next header or the end of the log.
+const (
+	groupMarkerCollapsed = "--- " // collapsed by default
+	groupMarkerExpanded  = "+++ " // expanded by default
+
+	// The Buildkite agent uses "~~~ " for its own groups (such as "~~~
+	// Preparing working directory"). They're collapsed, and otherwise
+	// rendered the same as "--- " groups.
+	groupMarkerAgent = "~~~ "
+)
+
+// groupHeader returns the group marker that the line begins with, or "" if
+// the line is not a group header.
+func groupHeader(parts []screenLine) string {
+	if len(parts) == 0 {
+		return ""
+	}
+	nodes := parts[0].nodes
+	for _, marker := range []string{groupMarkerCollapsed, groupMarkerExpanded, groupMarkerAgent} {
+		if len(nodes) < len(marker) {
+			continue
+		}
+		match := true
+		for i, r := range marker {
+			if nodes[i].style.element() || nodes[i].blob != r {
+				match = false
+				break
+			}
+		}
+		if match {
+			return marker
+		}
+	}
+	return ""
+}
+
+// groupState tracks the group that lines are being rendered into. It carries
+// over from one line to the next, including across lines scrolling out.
+type groupState struct {
+	// open is true once a header has been seen.
+	open bool
+
+	// Timestamps (milliseconds since the epoch) of the header, and of the most
+	// recent line in the group. They are 0 if unknown.
+	start, last int64
+
+	// hidden is true while the open group's header, and any lines after it,
+	// have been left out of the output (see WithTimeRange). The group's
+	// <details> element is output with the first line of the group that
+	// isn't left out, with the header (marker and HTML) as its summary.
+	hidden bool
+	marker string
+	header string
+
+	// closing closes the previous group, which was output, before its
+	// following header (which was left out). It's output with the next line.
+	closing groupChange
+}
+
+// groupChange describes how a line affects the groups.
+type groupChange struct {
+	// closed is true if the line closes the previous group, in which case
+	// duration is the time between its header and its final timestamped line
+	// (or this line), or -1 if not known.
+	closed   bool
+	duration time.Duration
+
+	// marker is the group marker if the line opens a new group, otherwise "".
+	// start is the timestamp of the header, or 0 if unknown.
+	marker string
+	start  int64
+
+	// header is the HTML of the group's header, if the group is opened by a
+	// line other than its header (which was left out of the output).
+	header string
+
+	// exit is true if the line is an exit marker (see groupExitMarker) in
+	// an open group.
+	exit bool
+}
+
+// advance updates the group state for the next line, and reports the change.
+func (g *groupState) advance(parts []screenLine) groupChange {
+	t, hasTime := lineTimestamp(parts)
+	change := g.closing
+	g.closing = groupChange{}
+
+	marker := groupHeader(parts)
+	if marker == "" {
+		if hasTime {
+			g.last = t
+		}
+		if g.hidden {
+			change.marker, change.start, change.header = g.marker, g.start, g.header
+			g.hidden, g.header = false, ""
+		}
+		change.exit = g.open && strings.HasPrefix(lineText(parts), groupExitMarker)
+		return change
+	}
+
+	if g.open && !g.hidden {
+		change = g.close(t)
+	}
+	change.marker = marker
+	if hasTime {
+		change.start = t
+	}
+	*g = groupState{open: true, start: change.start, last: change.start}
+	return change
+}
+
+// skip updates the group state for a line that is left out of the output
+// (see WithTimeRange). header returns the line's HTML, which is used as the
+// summary of the group if the line is a header, and later lines of the group
+// are output.
+func (g *groupState) skip(parts []screenLine, header func() string) {
+	t, hasTime := lineTimestamp(parts)
+
+	marker := groupHeader(parts)
+	if marker == "" {
+		if hasTime {
+			g.last = t
+		}
+		return
+	}
+
+	closing := g.closing
+	if g.open && !g.hidden {
+		closing = g.close(t)
+	}
+	var start int64
+	if hasTime {
+		start = t
+	}
+	*g = groupState{
+		open:    true,
+		start:   start,
+		last:    start,
+		hidden:  true,
+		marker:  marker,
+		header:  header(),
+		closing: closing,
+	}
+}
+
+// finish closes the open group (if any) at the end of the output.
+func (g *groupState) finish() groupChange {
+	change := g.closing
+	if g.open && !g.hidden {
+		change = g.close(0)
+	}
+	*g = groupState{}
+	return change
+}
+
+// close reports closing the open group. end is the timestamp of the line
+// following the group, or 0 if unknown.
+func (g *groupState) close(end int64) groupChange {
+	if end == 0 {
+		end = g.last
+	}
+	duration := time.Duration(-1)
+	if g.start != 0 && end >= g.start {
+		duration = time.Duration(end-g.start) * time.Millisecond
+	}
+	return groupChange{closed: true, duration: duration}
+}
+
+// closeHTML returns the HTML that closes the previous group, or "".
+func (c groupChange) closeHTML() string {
+	if !c.closed {
+		return ""
+	}
+	if c.duration < 0 {
+		return "</details>"
+	}
+	return fmt.Sprintf(`<time class="term-group-duration" datetime="%s">%s</time></details>`,
+		isoDuration(c.duration), c.duration)
+}
+
+// wrapHTML wraps a line rendered by lineToHTML with the markup for the
+// change: closing the previous group, and opening a new one with the line
+// (or the header left out of the output) as its summary.
+//
+// By the time an exit marker is seen, the <details> element of its group has
+// usually been written already (at least when streaming), so it can't be
+// expanded in the HTML. Instead, the exit marker line is wrapped in
+// <span class="term-group-exit">, and pages should expand the <details>
+// element containing it (as the preview page does).
+func (c groupChange) wrapHTML(line string) string {
+	var sb strings.Builder
+	sb.WriteString(c.closeHTML())
+	if c.marker != "" {
+		summary := c.header
+		if summary == "" {
+			summary = line
+		}
+		sb.WriteString(`<details class="term-group"`)
+		if c.marker == groupMarkerExpanded {
+			sb.WriteString(" open")
+		}
+		if c.start != 0 {
+			sb.WriteString(` data-group-start="`)
+			sb.WriteString(millisToTime(c.start).Format(timeTagLayout))
+			sb.WriteString(`"`)
+		}
+		sb.WriteString("><summary>")
+		sb.WriteString(strings.TrimSuffix(summary, "\n"))
+		sb.WriteString("</summary>\n")
+		if c.header == "" {
+			// The line is the header.
+			return sb.String()
+		}
+	}
+	if c.exit {
+		sb.WriteString(`<span class="term-group-exit">` + strings.TrimSuffix(line, "\n") + "</span>\n")
+	} else {
+		sb.WriteString(line)
+	}
+	return sb.String()
+}
+
+// isoDuration formats d as an ISO 8601 duration (e.g. PT1M2.345S), which is
+// one of the formats accepted by the datetime attribute of <time>.
+func isoDuration(d time.Duration) string {
+	d = d.Round(time.Millisecond)
+	var sb strings.Builder
+	sb.WriteString("PT")
+	if h := d / time.Hour; h > 0 {
+		sb.WriteString(strconv.Itoa(int(h)) + "H")
+		d -= h * time.Hour
+	}
+	if m := d / time.Minute; m > 0 {
+		sb.WriteString(strconv.Itoa(int(m)) + "M")
+		d -= m * time.Minute
+	}
+	sb.WriteString(strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "S")
+	return sb.String()
+}
+
+// groupExitMarker begins a line that expands the group containing it. The
+// Buildkite agent writes "^^^ +++" when a command fails, so that the output
+// leading up to the failure is visible.
+const groupExitMarker = "^^^ "
+
+// Section summarises one group of the log, or the lines before the first
+// group header.
+type Section struct {
+	// Marker is the group marker without the trailing space ("---", "+++" or
+	// "~~~"), or "" for the lines before the first group header.
+	Marker string
+
+	// Header is the plain text of the header line after the marker.
+	Header string
+
+	// Expanded reports whether the group is expanded by default, or was
+	// expanded by an exit marker.
+	Expanded bool
+
+	// Start and End are the first and last Buildkite timestamps in the
+	// section. If the following group header has a timestamp, that is used as
+	// End instead. They are zero if the section has no timestamps.
+	Start, End time.Time
+
+	// Lines is the number of lines in the section, including the header.
+	Lines int
+
+	// ExitMarkers contains the plain text of lines in the section beginning
+	// with "^^^ ".
+	ExitMarkers []string
+}
+
+// Duration returns the time between Start and End, or 0 if either is unknown.
+func (s Section) Duration() time.Duration {
+	if s.Start.IsZero() || s.End.IsZero() {
+		return 0
+	}
+	return s.End.Sub(s.Start)
+}
+
+// sectionState accumulates sections line by line.
+type sectionState struct {
+	done    []Section
+	current Section
+}
+
+// add adds a line to the sections.
+func (st *sectionState) add(parts []screenLine) {
+	var t time.Time
+	if millis, ok := lineTimestamp(parts); ok {
+		t = millisToTime(millis)
+	}
+	text := lineText(parts)
+
+	if marker := groupHeader(parts); marker != "" {
+		if st.current.Lines > 0 {
+			if !t.IsZero() {
+				st.current.End = t
+			}
+			st.done = append(st.done, st.current)
+		}
+		st.current = Section{
+			Marker:   strings.TrimSuffix(marker, " "),
+			Header:   strings.TrimSpace(strings.TrimPrefix(text, strings.TrimSuffix(marker, " "))),
+			Expanded: marker == groupMarkerExpanded,
+		}
+	}
+
+	st.current.Lines++
+	if !t.IsZero() {
+		if st.current.Start.IsZero() {
+			st.current.Start = t
+		}
+		st.current.End = t
+	}
+	if strings.HasPrefix(text, groupExitMarker) {
+		st.current.ExitMarkers = append(st.current.ExitMarkers, text)
+		st.current.Expanded = true
+	}
+}
+
+// clone returns a copy of the state that can be added to without affecting
+// the original.
+func (st *sectionState) clone() sectionState {
+	c := sectionState{done: slices.Clip(st.done), current: st.current}
+	c.current.ExitMarkers = slices.Clip(c.current.ExitMarkers)
+	return c
+}
+
+// sections returns the completed sections followed by the current one.
+func (st *sectionState) sections() []Section {
+	all := slices.Clip(st.done)
+	if st.current.Lines > 0 {
+		all = append(all, st.current)
+	}
+	return all
+}
@@ -0,0 +1,195 @@
+package terminal
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var groupTestCases = []struct {
+	name  string
+	input string
+	want  string
+}{
+	{
+		name:  "no groups",
+		input: "hello\n--world\n",
+		want:  "hello\n--world",
+	},
+	{
+		name:  "collapsed and expanded groups",
+		input: "before\n--- one\na\n+++ two\nb\n",
+		want: strings.Join([]string{
+			"before",
+			`<details class="term-group"><summary>--- one</summary>`,
+			"a",
+			`</details><details class="term-group" open><summary>+++ two</summary>`,
+			"b",
+			`</details>`,
+		}, "\n"),
+	},
+	{
+		name:  "~~~ groups are collapsed",
+		input: "~~~ setup\na",
+		want: strings.Join([]string{
+			`<details class="term-group"><summary>~~~ setup</summary>`,
+			"a",
+			`</details>`,
+		}, "\n"),
+	},
+	{
+		name:  "markers must start the line and be followed by a space",
+		input: " --- one\n---two\n---",
+		want:  " --- one\n---two\n---",
+	},
+	{
+		name: "durations from timestamps",
+		input: strings.Join([]string{
+			"\x1b_bk;t=1000\x07--- one",
+			"\x1b_bk;t=1500\x07a",
+			"\x1b_bk;t=63500\x07+++ two",
+			"\x1b_bk;t=64000\x07b",
+		}, "\n"),
+		want: strings.Join([]string{
+			`<details class="term-group" data-group-start="1970-01-01T00:00:01Z"><summary><time datetime="1970-01-01T00:00:01Z">1970-01-01T00:00:01Z</time>--- one</summary>`,
+			`<time datetime="1970-01-01T00:00:01.5Z">1970-01-01T00:00:01.5Z</time>a`,
+			`<time class="term-group-duration" datetime="PT1M2.5S">1m2.5s</time></details><details class="term-group" open data-group-start="1970-01-01T00:01:03.5Z"><summary><time datetime="1970-01-01T00:01:03.5Z">1970-01-01T00:01:03.5Z</time>+++ two</summary>`,
+			`<time datetime="1970-01-01T00:01:04Z">1970-01-01T00:01:04Z</time>b`,
+			`<time class="term-group-duration" datetime="PT0.5S">500ms</time></details>`,
+		}, "\n"),
+	},
+	{
+		name:  "exit markers in groups are marked",
+		input: "^^^ +++\n--- one\nfailed\n^^^ +++\n",
+		want: strings.Join([]string{
+			`^^^ +++`,
+			`<details class="term-group"><summary>--- one</summary>`,
+			`failed`,
+			`<span class="term-group-exit">^^^ +++</span>`,
+			`</details>`,
+		}, "\n"),
+	},
+	{
+		name:  "escapes HTML in headers",
+		input: "--- <b>bold</b>\n",
+		want:  `<details class="term-group"><summary>--- &lt;b&gt;bold&lt;&#47;b&gt;</summary>` + "\n</details>",
+	},
+}
+
+func TestGroups(t *testing.T) {
+	for _, test := range groupTestCases {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithGroups(true))
+			if err != nil {
+				t.Fatalf("NewScreen(WithGroups(true)) error = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if diff := cmp.Diff(s.AsHTML(), test.want); diff != "" {
+				t.Errorf("AsHTML() diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStreamingGroups(t *testing.T) {
+	for _, test := range groupTestCases {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithGroups(true), WithMaxSize(0, 2))
+			if err != nil {
+				t.Fatalf("NewScreen(WithGroups(true), WithMaxSize(0, 2)) error = %v", err)
+			}
+			var sb strings.Builder
+			s.ScrollOutFunc = func(line string) { sb.WriteString(line) }
+			s.Write([]byte(test.input))
+			sb.WriteString(s.AsHTML())
+			if diff := cmp.Diff(sb.String(), test.want); diff != "" {
+				t.Errorf("streamed HTML diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGroupsDisabledByDefault(t *testing.T) {
+	got := Render([]byte("--- one\na"))
+	if want := "--- one\na"; got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+}
+
+func TestISODuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "PT0S"},
+		{1500 * time.Millisecond, "PT1.5S"},
+		{2*time.Hour + 3*time.Minute + 4*time.Second, "PT2H3M4S"},
+	}
+	for _, test := range tests {
+		if got := isoDuration(test.d); got != test.want {
+			t.Errorf("isoDuration(%v) = %q, want %q", test.d, got, test.want)
+		}
+	}
+}
+
+func TestSections(t *testing.T) {
+	input := strings.Join([]string{
+		"\x1b_bk;t=1000\x07preamble",
+		"\x1b_bk;t=2000\x07--- :go: build",
+		"\x1b_bk;t=4500\x07compiling",
+		"\x1b_bk;t=5000\x07^^^ +++",
+		"+++ test",
+		"ok",
+		"~~~ ",
+	}, "\n")
+	want := []Section{
+		{
+			Start: millisToTime(1000),
+			End:   millisToTime(2000),
+			Lines: 1,
+		},
+		{
+			Marker:      "---",
+			Header:      ":go: build",
+			Expanded:    true,
+			Start:       millisToTime(2000),
+			End:         millisToTime(5000),
+			Lines:       3,
+			ExitMarkers: []string{"^^^ +++"},
+		},
+		{
+			Marker:   "+++",
+			Header:   "test",
+			Expanded: true,
+			Lines:    2,
+		},
+		{
+			Marker: "~~~",
+			Lines:  1,
+		},
+	}
+
+	for _, maxLines := range []int{0, 2} {
+		s, err := NewScreen(WithGroups(true), WithMaxSize(0, maxLines))
+		if err != nil {
+			t.Fatalf("NewScreen(WithGroups(true), WithMaxSize(0, %d)) error = %v", maxLines, err)
+		}
+		s.Write([]byte(input))
+		if diff := cmp.Diff(s.Sections(), want); diff != "" {
+			t.Errorf("with max lines %d: Sections() diff (-got +want):\n%s", maxLines, diff)
+		}
+		// Sections doesn't change the screen, so can be called repeatedly.
+		if diff := cmp.Diff(s.Sections(), want); diff != "" {
+			t.Errorf("with max lines %d: second Sections() diff (-got +want):\n%s", maxLines, diff)
+		}
+	}
+
+	if got := want[1].Duration(); got != 3*time.Second {
+		t.Errorf("Section.Duration() = %v, want %v", got, 3*time.Second)
+	}
+	if got := want[2].Duration(); got != 0 {
+		t.Errorf("Section.Duration() without timestamps = %v, want 0", got)
+	}
+}
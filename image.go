@@ -0,0 +1,109 @@
+package terminal
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html"
+	"mime"
+	"strings"
+)
+
+// ImagePolicy controls how images are rendered (see WithImagePolicy). Inline
+// images that the policy rejects are replaced with a placeholder naming the
+// image.
+//
+// The zero ImagePolicy allows any inline image, of any content type: set
+// AllowedContentTypes to block types such as image/svg+xml (which can
+// contain scripts, though browsers don't run them in <img>) or ones that
+// aren't images at all.
+type ImagePolicy struct {
+	// MaxSize, if positive, is the largest inline image, in decoded bytes,
+	// that is embedded in the output.
+	MaxSize int
+
+	// AllowedContentTypes, if not empty, lists the only content types of
+	// inline images that are embedded in the output (e.g. "image/png"). A
+	// type ending in "/*" allows all its subtypes, e.g. "image/*" (which
+	// includes image/svg+xml), and "*/*" allows every type. If it's empty,
+	// every content type is allowed.
+	AllowedContentTypes []string
+
+	// Lazy adds loading="lazy" to images, so browsers only load them when
+	// they're scrolled into view.
+	Lazy bool
+}
+
+// WithImagePolicy sets the policy for images.
+func WithImagePolicy(p ImagePolicy) ScreenOption {
+	return func(s *Screen) error {
+		if p.MaxSize < 0 {
+			return fmt.Errorf("image policy max size %d is negative", p.MaxSize)
+		}
+		np := ImagePolicy{MaxSize: p.MaxSize, Lazy: p.Lazy}
+		for _, ct := range p.AllowedContentTypes {
+			mt, _, err := mime.ParseMediaType(ct)
+			if err != nil {
+				return fmt.Errorf("invalid image content type %q: %w", ct, err)
+			}
+			np.AllowedContentTypes = append(np.AllowedContentTypes, mt)
+		}
+		s.imagePolicy = np
+		return nil
+	}
+}
+
+// check returns why the policy rejects an inline image, or "" if it doesn't.
+func (p ImagePolicy) check(contentType, content string) string {
+	if p.MaxSize > 0 && decodedLen(content) > p.MaxSize {
+		return "too large"
+	}
+	if len(p.AllowedContentTypes) > 0 && !p.contentTypeAllowed(contentType) {
+		return "unsupported type"
+	}
+	return ""
+}
+
+// contentTypeAllowed reports whether ct is one of the allowed content types.
+func (p ImagePolicy) contentTypeAllowed(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range p.AllowedContentTypes {
+		if allowed == "*/*" {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok {
+			if strings.HasPrefix(mt, prefix) {
+				return true
+			}
+		} else if mt == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// decodedLen returns the length of the decoded base64 content, which may
+// contain whitespace (such as line breaks), and may or may not be padded.
+func decodedLen(content string) int {
+	n := 0
+	for i := 0; i < len(content); i++ {
+		switch content[i] {
+		case ' ', '\t', '\r', '\n', '=':
+		default:
+			n++
+		}
+	}
+	return base64.RawStdEncoding.DecodedLen(n)
+}
+
+// placeholder returns the HTML shown instead of an image or file (kind) named
+// name, rejected by the image policy for reason.
+func placeholder(kind, name, reason string) string {
+	if name != "" {
+		name = " " + name
+	}
+	return `<span class="term-` + kind + `-placeholder">[` + kind + html.EscapeString(name) +
+		`: ` + html.EscapeString(reason) + `]</span>`
+}
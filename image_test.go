@@ -0,0 +1,115 @@
+package terminal
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestImagePolicy(t *testing.T) {
+	png := element{elementType: elementITermImage, url: "a.png", contentType: "image/png", content: "AAAA"}
+	svg := element{elementType: elementITermImage, url: "b.svg", contentType: "image/svg+xml", content: "AA=="}
+	external := element{elementType: elementImage, url: "https://example.org/c.svg"}
+
+	tests := []struct {
+		name    string
+		policy  ImagePolicy
+		element element
+		want    string
+	}{
+		{
+			name:    "zero policy",
+			element: svg,
+			want:    `<img alt="b.svg" src="data:image/svg+xml;base64,AA==">`,
+		},
+		{
+			name:    "within max size",
+			policy:  ImagePolicy{MaxSize: 3},
+			element: png,
+			want:    `<img alt="a.png" src="data:image/png;base64,AAAA">`,
+		},
+		{
+			name:    "over max size",
+			policy:  ImagePolicy{MaxSize: 2},
+			element: png,
+			want:    `<span class="term-image-placeholder">[image a.png: too large]</span>`,
+		},
+		{
+			name:    "allowed content type",
+			policy:  ImagePolicy{AllowedContentTypes: []string{"image/gif", "image/png"}},
+			element: png,
+			want:    `<img alt="a.png" src="data:image/png;base64,AAAA">`,
+		},
+		{
+			name:    "allowed content type wildcard",
+			policy:  ImagePolicy{AllowedContentTypes: []string{"image/*"}},
+			element: svg,
+			want:    `<img alt="b.svg" src="data:image/svg+xml;base64,AA==">`,
+		},
+		{
+			name:    "every content type",
+			policy:  ImagePolicy{AllowedContentTypes: []string{"*/*"}},
+			element: svg,
+			want:    `<img alt="b.svg" src="data:image/svg+xml;base64,AA==">`,
+		},
+		{
+			name:    "unlisted content type",
+			policy:  ImagePolicy{AllowedContentTypes: []string{"image/png"}},
+			element: svg,
+			want:    `<span class="term-image-placeholder">[image b.svg: unsupported type]</span>`,
+		},
+		{
+			name:    "content types don't apply to external images",
+			policy:  ImagePolicy{AllowedContentTypes: []string{"image/png"}, Lazy: true},
+			element: external,
+			want:    `<img alt="https://example.org/c.svg" src="https://example.org/c.svg" loading="lazy">`,
+		},
+		{
+			name:    "lazy loading",
+			policy:  ImagePolicy{Lazy: true},
+			element: png,
+			want:    `<img alt="a.png" src="data:image/png;base64,AAAA" loading="lazy">`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithImagePolicy(test.policy))
+			if err != nil {
+				t.Fatalf("NewScreen(WithImagePolicy(%+v)) error = %v", test.policy, err)
+			}
+			if diff := cmp.Diff(test.element.asHTML(s), test.want); diff != "" {
+				t.Errorf("asHTML() diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestImagePolicyErrors(t *testing.T) {
+	for _, p := range []ImagePolicy{
+		{MaxSize: -1},
+		{AllowedContentTypes: []string{"image/"}},
+	} {
+		if _, err := NewScreen(WithImagePolicy(p)); err == nil {
+			t.Errorf("NewScreen(WithImagePolicy(%+v)) error = nil, want error", p)
+		}
+	}
+}
+
+func TestDecodedLen(t *testing.T) {
+	for content, want := range map[string]int{
+		"":                 0,
+		"AA==":             1,
+		"AAA=":             2,
+		"AAAA":             3,
+		"AA":               1,
+		"AAA":              2,
+		"AAAA\nAA":         4,
+		"AAAA\r\nAAAA==\n": 6,
+		" AA == ":          1,
+	} {
+		if got := decodedLen(content); got != want {
+			t.Errorf("decodedLen(%q) = %d, want %d", content, got, want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Large iTerm2 files can be sent over several OSC sequences:
+//
+//	ESC ] 1337 ; MultipartFile = name=…;size=… BEL
+//	ESC ] 1337 ; FilePart = BASE64 BEL (repeated)
+//	ESC ] 1337 ; FileEnd BEL
+//
+// which is the same as one ESC ] 1337 ; File = … : BASE64 BEL sequence.
+
+// The largest (base64-encoded) multipart file accepted, so that a stream of
+// parts can't use unbounded memory.
+const maxITermFilePayload = 64 << 20
+
+// iTermTransfer is a multipart file transfer in progress.
+type iTermTransfer struct {
+	args    string
+	content strings.Builder
+}
+
+// handleITermMultipart handles the OSC sequences of a multipart file, and
+// reports whether sequence was one of them. When the file is complete, it
+// returns the equivalent single File= sequence to process.
+func (p *parser) handleITermMultipart(sequence string) (handled bool, file string, err error) {
+	if args, ok := strings.CutPrefix(sequence, "1337;MultipartFile="); ok {
+		p.iTermFile = &iTermTransfer{args: args}
+		return true, "", nil
+	}
+	if part, ok := strings.CutPrefix(sequence, "1337;FilePart="); ok {
+		t := p.iTermFile
+		if t == nil {
+			return true, "", nil
+		}
+		if t.content.Len()+len(part) > maxITermFilePayload {
+			p.iTermFile = nil
+			return true, "", errors.New("file too large")
+		}
+		t.content.WriteString(part)
+		return true, "", nil
+	}
+	if sequence == "1337;FileEnd" {
+		t := p.iTermFile
+		if t == nil {
+			return true, "", nil
+		}
+		p.iTermFile = nil
+		return true, "1337;File=" + t.args + ":" + t.content.String(), nil
+	}
+	return false, "", nil
+}
+
+// attachmentHTML renders a file that isn't shown inline as a link to download
+// it, with its size.
+func (i *element) attachmentHTML(s *Screen) string {
+	h := html.EscapeString
+	size := decodedLen(i.content)
+	if s.imagePolicy.MaxSize > 0 && size > s.imagePolicy.MaxSize {
+		return placeholder("file", i.url, "too large")
+	}
+
+	href := "data:" + i.contentType + ";base64," + i.content
+	if s.assetSink != nil {
+		url, err := s.assetURL(i)
+		if err != nil {
+			return placeholder("file", i.url, "couldn't store file")
+		}
+		href = url
+	}
+	return fmt.Sprintf(`<a class="term-attachment" href="%s" download="%s">%s</a> <span class="term-attachment-size">(%s)</span>`,
+		h(href), h(i.url), h(i.url), formatSize(size))
+}
+
+// formatSize formats a number of bytes for people, e.g. 1.5 kB.
+func formatSize(n int) string {
+	if n < 1000 {
+		return fmt.Sprintf("%d B", n)
+	}
+	f := float64(n) / 1000
+	for _, unit := range []string{"kB", "MB"} {
+		if f < 999.95 {
+			return fmt.Sprintf("%.1f %s", f, unit)
+		}
+		f /= 1000
+	}
+	return fmt.Sprintf("%.1f GB", f)
+}
@@ -0,0 +1,61 @@
+package terminal
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAttachmentHTML(t *testing.T) {
+	file := element{elementType: elementITermFile, url: "report.pdf", contentType: "application/pdf", content: "AAAA"}
+
+	tests := []struct {
+		name   string
+		policy ImagePolicy
+		want   string
+	}{
+		{
+			name: "download link",
+			want: `<a class="term-attachment" href="data:application/pdf;base64,AAAA" download="report.pdf">report.pdf</a> <span class="term-attachment-size">(3 B)</span>`,
+		},
+		{
+			name:   "content types only apply to images",
+			policy: ImagePolicy{AllowedContentTypes: []string{"image/*"}},
+			want:   `<a class="term-attachment" href="data:application/pdf;base64,AAAA" download="report.pdf">report.pdf</a> <span class="term-attachment-size">(3 B)</span>`,
+		},
+		{
+			name:   "over max size",
+			policy: ImagePolicy{MaxSize: 2},
+			want:   `<span class="term-file-placeholder">[file report.pdf: too large]</span>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithImagePolicy(test.policy))
+			if err != nil {
+				t.Fatalf("NewScreen(WithImagePolicy(%+v)) error = %v", test.policy, err)
+			}
+			if diff := cmp.Diff(file.asHTML(s), test.want); diff != "" {
+				t.Errorf("asHTML() diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for n, want := range map[int]string{
+		0:                 "0 B",
+		999:               "999 B",
+		1000:              "1.0 kB",
+		1536:              "1.5 kB",
+		999_999:           "1.0 MB",
+		2_500_000:         "2.5 MB",
+		3_000_000_000:     "3.0 GB",
+		5_000_000_000_000: "5000.0 GB",
+	} {
+		if got := formatSize(n); got != want {
+			t.Errorf("formatSize(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package terminal
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Kitty graphics protocol APCs (https://sw.kovidgoyal.net/kitty/graphics-protocol/)
+// look like:
+//
+//	ESC _ G a=T,f=100,m=1 ; BASE64 ESC \
+//
+// The control data is comma-separated key=value pairs. Large images are sent
+// in chunks: every chunk but the last has m=1, and only the first has the
+// other keys.
+
+// The largest (base64-encoded) image accepted, so that a stream of chunks
+// can't use unbounded memory.
+const maxKittyPayload = 64 << 20
+
+// The most (base64-encoded) bytes of images kept for later display by id, in
+// total, so that they can't use unbounded memory.
+const maxKittyStored = 64 << 20
+
+// kittyTransfer is a chunked transmission in progress.
+type kittyTransfer struct {
+	control map[string]string
+	payload strings.Builder
+}
+
+// isKittyGraphics reports whether an APC is a Kitty graphics APC, rather than
+// one for an APC namespace starting with G: its control data is key=value
+// pairs.
+func isKittyGraphics(sequence string) bool {
+	ctrl, _, _ := strings.Cut(sequence, ";")
+	rem, ok := strings.CutPrefix(ctrl, "G")
+	return ok && (rem == "" || strings.Contains(rem, "="))
+}
+
+// handleKittyGraphics handles a Kitty graphics APC (without the leading G).
+func (p *parser) handleKittyGraphics(sequence string) {
+	ctrl, payload, _ := strings.Cut(sequence, ";")
+	control := make(map[string]string)
+	for _, kv := range strings.Split(ctrl, ",") {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			control[k] = v
+		}
+	}
+
+	if p.kitty == nil {
+		p.kitty = &kittyTransfer{control: control}
+	}
+	t := p.kitty
+	if t.payload.Len()+len(payload) > maxKittyPayload {
+		p.kitty = nil
+		p.kittyError(errors.New("image too large"))
+		return
+	}
+	t.payload.WriteString(payload)
+	if control["m"] == "1" {
+		// More chunks to come.
+		return
+	}
+	p.kitty = nil
+
+	switch action := t.control["a"]; action {
+	case "", "t", "T": // transmit (and display)
+		elem, err := kittyImage(t.control, t.payload.String())
+		if err != nil {
+			p.kittyError(err)
+			return
+		}
+		if id := t.control["i"]; id != "" {
+			if p.kittyImages == nil {
+				p.kittyImages = make(map[string]*element)
+			}
+			stored := p.kittyStored + len(elem.content)
+			if old := p.kittyImages[id]; old != nil {
+				stored -= len(old.content)
+			}
+			if stored > maxKittyStored {
+				p.kittyError(errors.New("stored images too large"))
+				return
+			}
+			p.kittyImages[id] = elem
+			p.kittyStored = stored
+		}
+		if action == "T" {
+			p.displayImage(elem)
+		}
+
+	case "p": // put (display a transmitted image)
+		elem := p.kittyImages[t.control["i"]]
+		if elem == nil {
+			return
+		}
+		if t.control["c"] != "" || t.control["r"] != "" {
+			resized := *elem
+			resized.width, resized.height = kittyImageSize(t.control)
+			elem = &resized
+		}
+		p.displayImage(elem)
+
+	case "d": // delete
+		switch t.control["d"] {
+		case "", "a", "A":
+			p.kittyImages, p.kittyStored = nil, 0
+		case "i", "I":
+			if old := p.kittyImages[t.control["i"]]; old != nil {
+				p.kittyStored -= len(old.content)
+				delete(p.kittyImages, t.control["i"])
+			}
+		}
+	}
+	// Anything else (such as a query) doesn't affect the output.
+}
+
+// kittyError renders an error handling a Kitty graphics APC on its own line.
+func (p *parser) kittyError(err error) {
+	p.displayError("*** Error handling Kitty graphics APC ANSI escape sequence: ", err)
+}
+
+// kittyImage returns an image element for a transmitted image. PNG images are
+// used as they are, and raw RGB and RGBA pixels are converted to PNG.
+func kittyImage(control map[string]string, payload string) (*element, error) {
+	if t := control["t"]; t != "" && t != "d" {
+		return nil, fmt.Errorf("unsupported transmission medium %q", t)
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("expected payload to be valid Base64")
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("image content missing")
+	}
+
+	switch control["o"] {
+	case "":
+	case "z":
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("decompressing payload: %w", err)
+		}
+		data, err = io.ReadAll(io.LimitReader(zr, maxKittyPayload+1))
+		if err != nil {
+			return nil, fmt.Errorf("decompressing payload: %w", err)
+		}
+		if len(data) > maxKittyPayload {
+			return nil, errors.New("image too large")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", control["o"])
+	}
+
+	switch f := control["f"]; f {
+	case "100":
+		// Already a PNG.
+	case "", "24", "32":
+		data, err = kittyPixelsToPNG(f != "24", control["s"], control["v"], data)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported format %q", f)
+	}
+
+	elem := &element{
+		elementType: elementITermImage,
+		contentType: "image/png",
+		content:     base64.StdEncoding.EncodeToString(data),
+	}
+	elem.width, elem.height = kittyImageSize(control)
+	return elem, nil
+}
+
+// kittyImageSize returns the display size of an image, from the number of
+// columns and rows it should take up.
+func kittyImageSize(control map[string]string) (width, height string) {
+	if c := control["c"]; c != "" {
+		width = parseImageDimension(c)
+	}
+	if r := control["r"]; r != "" {
+		height = parseImageDimension(r)
+	}
+	return width, height
+}
+
+// kittyPixelsToPNG encodes raw 24-bit RGB or 32-bit RGBA pixels as a PNG.
+func kittyPixelsToPNG(alpha bool, width, height string, pixels []byte) ([]byte, error) {
+	w, err1 := strconv.Atoi(width)
+	h, err2 := strconv.Atoi(height)
+	if err1 != nil || err2 != nil || w <= 0 || h <= 0 || w > maxKittyPayload || h > maxKittyPayload {
+		return nil, fmt.Errorf("s= and v= arguments must give the image size for raw pixels")
+	}
+	bpp := 3
+	if alpha {
+		bpp = 4
+	}
+	if len(pixels) != w*h*bpp {
+		return nil, fmt.Errorf("got %d bytes of pixels, want %d for a %dx%d image", len(pixels), w*h*bpp, w, h)
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := range w * h {
+		px := pixels[i*bpp : (i+1)*bpp]
+		copy(img.Pix[i*4:], px)
+		if !alpha {
+			img.Pix[i*4+3] = 0xff
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("encoding PNG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,149 @@
+package terminal
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestKittyGraphics(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "transmit and display",
+			input: "before\x1b_Ga=T,f=100,c=10,r=2;AAAA\x1b\\after",
+			want: strings.Join([]string{
+				"before",
+				`<img alt="" src="data:image/png;base64,AAAA" width="10em" height="2em">`,
+				"after",
+			}, "\n"),
+		},
+		{
+			name:  "chunked",
+			input: "\x1b_Ga=T,f=100,m=1;AAAA\x1b\\\x1b_Gm=1;BBBB\x1b\\\x1b_Gm=0;CA==\x1b\\",
+			want:  `<img alt="" src="data:image/png;base64,AAAABBBBCA==">`,
+		},
+		{
+			name:  "transmit then put",
+			input: "\x1b_Ga=t,f=100,i=7;AAAA\x1b\\quiet\n\x1b_Ga=p,i=7,c=3\x1b\\\x1b_Ga=p,i=8\x1b\\",
+			want: strings.Join([]string{
+				"quiet",
+				`<img alt="" src="data:image/png;base64,AAAA" width="3em">`,
+			}, "\n"),
+		},
+		{
+			name:  "deleted images can't be put",
+			input: "\x1b_Ga=t,f=100,i=7;AAAA\x1b\\\x1b_Ga=d,d=i,i=7\x1b\\\x1b_Ga=p,i=7\x1b\\",
+			want:  "",
+		},
+		{
+			name:  "unsupported medium",
+			input: "\x1b_Ga=T,t=f;L3RtcC9hLnBuZw==\x1b\\",
+			want:  `*** Error handling Kitty graphics APC ANSI escape sequence: unsupported transmission medium &quot;f&quot;`,
+		},
+		{
+			name:  "wrong size of raw pixels",
+			input: "\x1b_Ga=T,f=24,s=2,v=1;AAAA\x1b\\",
+			want:  `*** Error handling Kitty graphics APC ANSI escape sequence: got 3 bytes of pixels, want 6 for a 2x1 image`,
+		},
+		{
+			name:  "other APC namespaces starting with G",
+			input: "\x1b_Gitlab;a=T\x1b\\ok",
+			want:  "ok",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen()
+			if err != nil {
+				t.Fatalf("NewScreen() error = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if diff := cmp.Diff(s.AsHTML(), test.want); diff != "" {
+				t.Errorf("AsHTML() diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestKittyRawPixels(t *testing.T) {
+	// Two pixels, red and translucent green, zlib compressed.
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write([]byte{0xff, 0, 0, 0xff, 0, 0xff, 0, 0x80})
+	zw.Close()
+	payload := base64.StdEncoding.EncodeToString(z.Bytes())
+
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() error = %v", err)
+	}
+	s.Write([]byte("\x1b_Ga=T,f=32,o=z,s=2,v=1;" + payload + "\x1b\\"))
+
+	line := s.screen[0]
+	if len(line.elements) != 1 {
+		t.Fatalf("got %d elements, want 1", len(line.elements))
+	}
+	elem := line.elements[0]
+	if elem.contentType != "image/png" {
+		t.Errorf("contentType = %q, want image/png", elem.contentType)
+	}
+	data, err := base64.StdEncoding.DecodeString(elem.content)
+	if err != nil {
+		t.Fatalf("base64 decoding content: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("png.Decode(content) error = %v", err)
+	}
+	got := []color.Color{img.At(0, 0), img.At(1, 0)}
+	want := []color.Color{color.NRGBA{0xff, 0, 0, 0xff}, color.NRGBA{0, 0xff, 0, 0x80}}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("pixels diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestKittyStoredLimit(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() error = %v", err)
+	}
+	half := strings.Repeat("A", maxKittyStored/2)
+	store := func(id string) {
+		s.parser.handleKittyGraphics("a=t,f=100,i=" + id + ";" + half)
+	}
+
+	store("1")
+	store("2")
+	store("2") // replacing an image doesn't count it twice
+	if got := s.AsPlainText(); got != "" {
+		t.Fatalf("after storing images that fit, AsPlainText() = %q, want no errors", got)
+	}
+	store("3")
+	want := "*** Error handling Kitty graphics APC ANSI escape sequence: stored images too large"
+	if got := s.AsPlainText(); got != want {
+		t.Errorf("after storing too much, AsPlainText() = %q, want %q", got, want)
+	}
+	if _, ok := s.parser.kittyImages["3"]; ok {
+		t.Errorf("image 3 was stored, want it rejected")
+	}
+
+	s.parser.handleKittyGraphics("a=d,d=i,i=1")
+	store("3")
+	if _, ok := s.parser.kittyImages["3"]; !ok {
+		t.Errorf("image 3 wasn't stored after deleting image 1")
+	}
+	if got, want := s.parser.kittyStored, maxKittyStored; got != want {
+		t.Errorf("kittyStored = %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,199 @@
+package terminal
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// LinkRule turns text matching a pattern into a link (see WithLinkifier).
+type LinkRule struct {
+	// Pattern matches the text to link. If it has a submatch named "text",
+	// only that part of the match is linked, so the rest can match the
+	// context around it.
+	Pattern *regexp.Regexp
+
+	// URL is the link target. Each {name} is replaced with the submatch of
+	// Pattern with that name. If URL is empty, the linked text is the URL.
+	URL string
+
+	// skip, if not nil, reports whether text matched by Pattern shouldn't be
+	// linked after all, for checks that regexp can't express.
+	skip func(text string) bool
+}
+
+// URLLinks returns a rule that links bare http and https URLs. Trailing
+// punctuation, such as the full stop at the end of a sentence, isn't
+// considered part of the URL.
+func URLLinks() LinkRule {
+	return LinkRule{
+		Pattern: regexp.MustCompile(`\bhttps?://[^\s<>"'` + "`" + `]*[^\s<>"'` + "`" + `.,:;!?)\]]`),
+	}
+}
+
+// FileLinks returns a rule that links relative file paths with a line number,
+// and optionally a column, as output by compilers and test runners (e.g.
+// path/to/file.go:123 or file.go:123:4). The URL can use {path}, {line} and
+// {col}, e.g. "https://github.com/org/repo/blob/<commit>/{path}#L{line}".
+//
+// File names need an extension containing a letter, so that addresses like
+// 127.0.0.1:8080 aren't linked, and neither are host names with a port like
+// example.com:443 (file names without a directory, with an extension that's
+// a common top-level domain). Paths can't go up a directory with "..".
+func FileLinks(url string) LinkRule {
+	return LinkRule{
+		Pattern: regexp.MustCompile(`(?:^|[^\w./@-])(?P<text>(?:\./)?(?P<path>(?:\.?[\w-][\w.-]*/)*\.?[\w-][\w.-]*\.\w*[A-Za-z]\w*):(?P<line>\d+)(?::(?P<col>\d+))?)\b`),
+		URL:     url,
+		skip:    isHostLike,
+	}
+}
+
+// Top-level domains that are rarely file extensions.
+var hostLikeTLDs = []string{"com", "net", "org", "io", "dev", "app", "cloud", "internal", "local"}
+
+// isHostLike reports whether a file link's text (path:line) looks more like
+// a host name and port.
+func isHostLike(text string) bool {
+	text = strings.TrimPrefix(text, "./")
+	path, _, _ := strings.Cut(text, ":")
+	if strings.Contains(path, "/") {
+		return false
+	}
+	ext := path[strings.LastIndex(path, ".")+1:]
+	return slices.Contains(hostLikeTLDs, strings.ToLower(ext))
+}
+
+// IssueLinks returns a rule that links issue references like #1234. The URL
+// can use {number}, e.g. "https://github.com/org/repo/issues/{number}".
+func IssueLinks(url string) LinkRule {
+	return LinkRule{
+		Pattern: regexp.MustCompile(`(?:^|[^\w&#/])(?P<text>#(?P<number>\d+))\b`),
+		URL:     url,
+	}
+}
+
+// TicketLinks returns a rule that links ticket references like JIRA-123, for
+// the given projects (e.g. "JIRA"), or any project if none are given. The URL
+// can use {key} (e.g. JIRA-123), {project} and {number}, e.g.
+// "https://example.atlassian.net/browse/{key}".
+func TicketLinks(url string, projects ...string) LinkRule {
+	project := `[A-Z][A-Z0-9]+`
+	if len(projects) > 0 {
+		quoted := make([]string, len(projects))
+		for i, p := range projects {
+			quoted[i] = regexp.QuoteMeta(p)
+		}
+		project = strings.Join(quoted, "|")
+	}
+	return LinkRule{
+		Pattern: regexp.MustCompile(`\b(?P<key>(?P<project>` + project + `)-(?P<number>\d+))\b`),
+		URL:     url,
+	}
+}
+
+// WithLinkifier turns text in the output that matches any of the rules into
+// links, like those made with OSC 8 sequences. Where matches overlap, the
+// earlier rule wins; text that's already a link isn't changed. Link URLs are
+// checked against the URL policy (see WithURLPolicy).
+//
+// Only HTML output is affected.
+func WithLinkifier(rules ...LinkRule) ScreenOption {
+	return func(s *Screen) error {
+		for i, r := range rules {
+			if r.Pattern == nil {
+				return fmt.Errorf("link rule %d has no pattern", i)
+			}
+		}
+		s.linkRules = rules
+		return nil
+	}
+}
+
+// linkify finds the text in a line that the link rules match. It returns the
+// URL for each node in each part, or nil if nothing matched.
+func (s *Screen) linkify(parts []screenLine) [][]string {
+	// Join the line's text together, remembering where each node is. Nodes
+	// that can't be linked (elements and existing links) are replaced with a
+	// character that doesn't match \w or \s.
+	type nodePos struct{ part, x int }
+	var text strings.Builder
+	var positions []nodePos // for each byte of text
+	var unlinkable []bool   // for each byte of text
+	for i, l := range parts {
+		for x, n := range l.nodes {
+			r, fixed := n.blob, n.style.element() || n.style.hyperlink()
+			if fixed {
+				r = '\uFFFC'
+			}
+			size, _ := text.WriteRune(r)
+			for range size {
+				positions = append(positions, nodePos{i, x})
+				unlinkable = append(unlinkable, fixed)
+			}
+		}
+	}
+	str := text.String()
+
+	var links [][]string
+	taken := make([]bool, len(str))
+	for _, rule := range s.linkRules {
+		group := rule.Pattern.SubexpIndex("text")
+		for _, m := range rule.Pattern.FindAllStringSubmatchIndex(str, -1) {
+			start, end := m[0], m[1]
+			if group >= 0 {
+				start, end = m[2*group], m[2*group+1]
+			}
+			if start < 0 || start == end || overlaps(taken[start:end], unlinkable[start:end]) {
+				continue
+			}
+			if rule.skip != nil && rule.skip(str[start:end]) {
+				continue
+			}
+
+			url := str[start:end]
+			if rule.URL != "" {
+				url = expandLinkURL(rule, str, m)
+			}
+			if links == nil {
+				links = make([][]string, len(parts))
+				for i, l := range parts {
+					links[i] = make([]string, len(l.nodes))
+				}
+			}
+			for b := start; b < end; b++ {
+				taken[b] = true
+				p := positions[b]
+				links[p.part][p.x] = url
+			}
+		}
+	}
+	return links
+}
+
+// overlaps reports whether any of a or b are true.
+func overlaps(a, b []bool) bool {
+	for i := range a {
+		if a[i] || b[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// expandLinkURL replaces each {name} in the rule's URL with the named
+// submatch of match.
+func expandLinkURL(rule LinkRule, str string, match []int) string {
+	var oldnew []string
+	for i, name := range rule.Pattern.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		var val string
+		if match[2*i] >= 0 {
+			val = str[match[2*i]:match[2*i+1]]
+		}
+		oldnew = append(oldnew, "{"+name+"}", val)
+	}
+	return strings.NewReplacer(oldnew...).Replace(rule.URL)
+}
@@ -0,0 +1,100 @@
+package terminal
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLinkifier(t *testing.T) {
+	const blob = "https://github.com/org/repo/blob/abc123/{path}#L{line}"
+	tests := []struct {
+		name  string
+		rules []LinkRule
+		input string
+		want  string
+	}{
+		{
+			name:  "bare URLs",
+			rules: []LinkRule{URLLinks()},
+			input: "see https://example.org/a?b=c&d=e. (https://example.org/x)",
+			want:  `see <a href="https://example.org/a?b=c&amp;d=e">https:&#47;&#47;example.org&#47;a?b=c&amp;d=e</a>. (<a href="https://example.org/x">https:&#47;&#47;example.org&#47;x</a>)`,
+		},
+		{
+			name:  "file paths",
+			rules: []LinkRule{FileLinks(blob)},
+			input: "main.go:12:3: oops\n./cmd/x.go:4 and /abs/y.go:5",
+			want: `<a href="https://github.com/org/repo/blob/abc123/main.go#L12">main.go:12:3</a>: oops` + "\n" +
+				`<a href="https://github.com/org/repo/blob/abc123/cmd/x.go#L4">.&#47;cmd&#47;x.go:4</a> and &#47;abs&#47;y.go:5`,
+		},
+		{
+			name:  "file links with hidden directories",
+			rules: []LinkRule{FileLinks(blob)},
+			input: ".github/ci.yml:3 .env.go:1",
+			want: `<a href="https://github.com/org/repo/blob/abc123/.github/ci.yml#L3">.github&#47;ci.yml:3</a> ` +
+				`<a href="https://github.com/org/repo/blob/abc123/.env.go#L1">.env.go:1</a>`,
+		},
+		{
+			name:  "not file links",
+			rules: []LinkRule{FileLinks(blob)},
+			input: "127.0.0.1:8080 example.com:443 api.internal:80 v1.2:3 user@host.go:22 ../secret.go:1 a/../b.go:2",
+			want:  "127.0.0.1:8080 example.com:443 api.internal:80 v1.2:3 user@host.go:22 ..&#47;secret.go:1 a&#47;..&#47;b.go:2",
+		},
+		{
+			name:  "issues",
+			rules: []LinkRule{IssueLinks("https://github.com/org/repo/issues/{number}")},
+			input: "fixes #12, not a#3 or &#34;",
+			want:  `fixes <a href="https://github.com/org/repo/issues/12">#12</a>, not a#3 or &amp;#34;`,
+		},
+		{
+			name:  "tickets",
+			rules: []LinkRule{TicketLinks("https://example.atlassian.net/browse/{key}", "JIRA")},
+			input: "JIRA-123 UTF-8",
+			want:  `<a href="https://example.atlassian.net/browse/JIRA-123">JIRA-123</a> UTF-8`,
+		},
+		{
+			name:  "earlier rules win",
+			rules: []LinkRule{URLLinks(), IssueLinks("/issues/{number}")},
+			input: "https://example.org/#1 #2",
+			want:  `<a href="https://example.org/#1">https:&#47;&#47;example.org&#47;#1</a> <a href="/issues/2">#2</a>`,
+		},
+		{
+			name:  "existing links are kept",
+			rules: []LinkRule{URLLinks()},
+			input: "\x1b]8;;https://a.example/\x1b\\https://b.example/\x1b]8;;\x1b\\",
+			want:  `<a href="https://a.example/">https:&#47;&#47;b.example&#47;</a>`,
+		},
+		{
+			name:  "styles inside links",
+			rules: []LinkRule{URLLinks()},
+			input: "https://\x1b[31mexample.org\x1b[0m/",
+			want:  `<a href="https://example.org/">https:&#47;&#47;<span class="term-fg31">example.org</span>&#47;</a>`,
+		},
+		{
+			name:  "URL policy applies",
+			rules: []LinkRule{{Pattern: regexp.MustCompile(`run \w+`), URL: "javascript:alert(1)"}},
+			input: "run this",
+			want:  `<a href="#">run this</a>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(WithLinkifier(test.rules...))
+			if err != nil {
+				t.Fatalf("NewScreen(WithLinkifier(...)) error = %v", err)
+			}
+			s.Write([]byte(test.input))
+			if diff := cmp.Diff(s.AsHTML(), test.want); diff != "" {
+				t.Errorf("AsHTML() diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLinkifierErrors(t *testing.T) {
+	if _, err := NewScreen(WithLinkifier(LinkRule{URL: "https://example.org/"})); err == nil {
+		t.Error("NewScreen(WithLinkifier(LinkRule{...})) without a pattern error = nil, want error")
+	}
+}
@@ -10,11 +10,17 @@ import (
 	"time"
 )
 
-var (
-	timeTagImpl = template.Must(template.New("time").Parse(
-		`<time datetime="{{.}}">{{.}}</time>`,
-	))
+// One of the formats accepted by the <time> tag.
+const timeTagLayout = "2006-01-02T15:04:05.999Z"
+
+// Default layouts for the visible text of timestamps. For UTC these are the
+// same as timeTagLayout and time.RFC3339 respectively.
+const (
+	defaultHTMLTimestampLayout  = "2006-01-02T15:04:05.999Z07:00"
+	defaultPlainTimestampLayout = time.RFC3339
+)
 
+var (
 	openSpanTagTmpl = template.Must(template.New("span").Parse(
 		`<span class="{{.}}">`,
 	))
@@ -32,29 +38,48 @@ func (b *outputBuffer) closeStyle() {
 	b.WriteString("</span>")
 }
 
-func (b *outputBuffer) appendAnchor(url string) {
+// appendAnchor opens a link to url, checked against the screen's URL policy,
+// with attributes from its link attribute policy. If id is not empty, it's
+// the OSC 8 link id, as a data-link-id attribute.
+func (b *outputBuffer) appendAnchor(s *Screen, url, id string) {
+	url = s.urlPolicy.sanitize(url)
 	b.WriteString(`<a href="`)
-	b.WriteString(html.EscapeString(sanitizeURL(url)))
-	b.WriteString(`">`)
+	b.WriteString(html.EscapeString(url))
+	b.WriteString(`"`)
+	if id != "" {
+		b.WriteString(` data-link-id="`)
+		b.WriteString(html.EscapeString(id))
+		b.WriteString(`"`)
+	}
+	if s.linkAttrs != nil {
+		attrs := s.linkAttrs(url)
+		for _, a := range []struct{ name, value string }{
+			{"target", attrs.Target},
+			{"rel", attrs.Rel},
+			{"referrerpolicy", attrs.ReferrerPolicy},
+		} {
+			if a.value != "" {
+				b.WriteString(` ` + a.name + `="`)
+				b.WriteString(html.EscapeString(a.value))
+				b.WriteString(`"`)
+			}
+		}
+	}
+	b.WriteString(`>`)
 }
 
 func (b *outputBuffer) closeAnchor() {
 	b.WriteString("</a>")
 }
 
-func (b *outputBuffer) appendMeta(namespace string, data map[string]string) {
-	// We only support the bk namespace and a well-formed millisecond epoch.
-	if namespace != bkNamespace {
-		return
-	}
-	millis, err := strconv.ParseInt(data["t"], 10, 64)
-	if err != nil {
-		return
-	}
-	time := time.Unix(millis/1000, (millis%1000)*1_000_000).UTC()
-	// One of the formats accepted by the <time> tag:
-	datetime := time.Format("2006-01-02T15:04:05.999Z")
-	timeTagImpl.Execute(b, datetime)
+// appendTimestamp appends a <time> element for a Buildkite timestamp, with
+// text as its contents.
+func (b *outputBuffer) appendTimestamp(millis int64, text string) {
+	b.WriteString(`<time datetime="`)
+	b.WriteString(millisToTime(millis).Format(timeTagLayout))
+	b.WriteString(`">`)
+	b.WriteString(html.EscapeString(text))
+	b.WriteString(`</time>`)
 }
 
 // Append a character to our outputbuffer, escaping HTML bits as necessary.
@@ -77,19 +102,67 @@ func (b *outputBuffer) appendChar(char rune) {
 	}
 }
 
+// appendMetaAttrs appends a data-<namespace>-<key> attribute for each key in
+// data selected by sels (see WithMetadataAttributes), in key order.
+func (b *outputBuffer) appendMetaAttrs(sels []string, namespace string, data map[string]string) {
+	for _, key := range slices.Sorted(maps.Keys(data)) {
+		if !metadataAttributeSelected(sels, namespace, key) {
+			continue
+		}
+		b.WriteString(` data-`)
+		b.WriteString(strings.ToLower(namespace))
+		b.WriteString(`-`)
+		b.WriteString(strings.ToLower(key))
+		b.WriteString(`="`)
+		b.WriteString(html.EscapeString(data[key]))
+		b.WriteString(`"`)
+	}
+}
+
 // lineToHTML joins parts of a line together and renders them in HTML. It
 // ignores the newline field (i.e. assumes all parts are !newline except the
-// last part). The output string will have a terminating \n.
-func lineToHTML(parts []screenLine, timestamps bool) string {
+// last part). gap is the time the line took, or noGap if unknown. The output
+// string will have a terminating \n.
+func (s *Screen) lineToHTML(parts []screenLine, timestamps bool, gap time.Duration) string {
 	var buf outputBuffer
 
 	// Combine metadata - last metadata wins.
-	bkmd := make(map[string]string)
-	for _, l := range parts {
-		maps.Copy(bkmd, l.metadata[bkNamespace])
+	bkmd := lineMetadata(parts, bkNamespace)
+
+	// Slow lines, and lines with selected metadata, are wrapped in an element
+	// carrying the class and metadata attributes.
+	class := "term-line"
+	if s.slow(gap) {
+		class += " term-slow"
+	}
+	var attrs outputBuffer
+	if len(s.metadataAttrs) > 0 {
+		for _, ns := range lineNamespaces(parts) {
+			md := bkmd
+			if ns != bkNamespace {
+				md = lineMetadata(parts, ns)
+			}
+			attrs.appendMetaAttrs(s.metadataAttrs, ns, md)
+		}
+	}
+	wrap := attrs.Len() > 0 || s.slow(gap)
+	if wrap {
+		buf.WriteString(`<span class="`)
+		buf.WriteString(class)
+		buf.WriteString(`"`)
+		buf.WriteString(attrs.String())
+		buf.WriteString(`>`)
+	}
+	contentStart := buf.Len()
+
+	// We only support a well-formed millisecond epoch.
+	if timestamps {
+		if millis, err := strconv.ParseInt(bkmd["t"], 10, 64); err == nil {
+			buf.appendTimestamp(millis, s.formatTimestamp(millis, defaultHTMLTimestampLayout))
+		}
 	}
-	if timestamps && len(bkmd) > 0 {
-		buf.appendMeta(bkNamespace, bkmd)
+	if s.elapsed && gap >= 0 {
+		buf.appendGap(gap)
 	}
 
 	// tagStack is used as a stack of open tags, so they can be closed in the
@@ -117,16 +190,30 @@ func lineToHTML(parts []screenLine, timestamps bool) string {
 
 	// The zero value for node has a plain style and no hyperlink.
 	var previous node
+	var previousLink, previousLinkID string
 
-	for _, l := range parts {
+	// Links found by the linkifier (see WithLinkifier).
+	var autoLinks [][]string
+	if len(s.linkRules) > 0 {
+		autoLinks = s.linkify(parts)
+	}
+
+	for i, l := range parts {
 		for x, current := range l.nodes {
+			// The node's link URL, or "" if it isn't a link, and OSC 8 link id.
+			link, linkID := l.hyperlinks[x], l.linkIDs[x]
+			if !current.style.hyperlink() {
+				link, linkID = "", ""
+				if autoLinks != nil {
+					link = autoLinks[i][x]
+				}
+			}
+
 			// A set of flags for which tags need changing.
 			tagChanged := []bool{
-				// The anchor tag needs changing if the link "style" has changed,
-				// or if they are both links the link URLs are different.
-				// (Note that the x-1 index into the hyperlinks map returns "".)
-				tagAnchor: current.style.hyperlink() != previous.style.hyperlink() ||
-					(current.style.hyperlink() && l.hyperlinks[x-1] != l.hyperlinks[x]),
+				// The anchor tag needs changing if the link URL or id has
+				// changed, including to or from not being a link.
+				tagAnchor: link != previousLink || linkID != previousLinkID,
 
 				// The span tag needs changing if the style has changed.
 				tagSpan: !current.hasSameStyle(previous),
@@ -149,8 +236,8 @@ func lineToHTML(parts []screenLine, timestamps bool) string {
 			// Now open new tags as needed.
 			// Open a new anchor tag, if one is not already open and this node is
 			// hyperlinked.
-			if !slices.Contains(tagStack, tagAnchor) && current.style.hyperlink() {
-				buf.appendAnchor(l.hyperlinks[x])
+			if !slices.Contains(tagStack, tagAnchor) && link != "" {
+				buf.appendAnchor(s, link, linkID)
 				tagStack = append(tagStack, tagAnchor)
 			}
 			// Open a new span tag, if one is not already open and this node has
@@ -162,12 +249,12 @@ func lineToHTML(parts []screenLine, timestamps bool) string {
 
 			// Write a standalone element or a rune.
 			if current.style.element() {
-				buf.WriteString(l.elements[current.blob].asHTML())
+				buf.WriteString(l.elements[current.blob].asHTML(s))
 			} else {
 				buf.appendChar(current.blob)
 			}
 
-			previous = current
+			previous, previousLink, previousLinkID = current, link, linkID
 		}
 	}
 
@@ -175,11 +262,13 @@ func lineToHTML(parts []screenLine, timestamps bool) string {
 	closeFrom(0)
 
 	out := strings.TrimRight(buf.String(), " \t")
-	if out == "" {
-		return "&nbsp;\n"
+	if len(out) == contentStart {
+		out += "&nbsp;"
 	}
-	out += "\n"
-	return out
+	if wrap {
+		out += "</span>"
+	}
+	return out + "\n"
 }
 
 // asPlain returns the line contents without any added HTML.
@@ -199,28 +288,10 @@ func (l *screenLine) asPlain() string {
 	return line
 }
 
-// lineToPlain joins parts of a line together and renders them as plain text,
-// optionally with a UTC timestamp prefix. The output string will have a
-// terminating \n.
-func lineToPlain(parts []screenLine, timestamps bool) string {
+// lineText joins parts of a line together as plain text, without trailing
+// whitespace or a terminating \n.
+func lineText(parts []screenLine) string {
 	var buf strings.Builder
-
-	if timestamps {
-		// Combine metadata - last metadata wins.
-		bkmd := make(map[string]string)
-		for _, l := range parts {
-			maps.Copy(bkmd, l.metadata[bkNamespace])
-		}
-		if t, ok := bkmd["t"]; ok {
-			if millis, err := strconv.ParseInt(t, 10, 64); err == nil {
-				ts := time.Unix(millis/1000, (millis%1000)*1_000_000).UTC()
-				buf.WriteString(ts.Format("2006-01-02T15:04:05Z"))
-				buf.WriteString(" ")
-			}
-		}
-	}
-
-	// Render the text content.
 	for _, l := range parts {
 		for _, node := range l.nodes {
 			if !node.style.element() {
@@ -228,11 +299,23 @@ func lineToPlain(parts []screenLine, timestamps bool) string {
 			}
 		}
 	}
+	return strings.TrimRight(buf.String(), " \t")
+}
 
-	line := buf.String()
-	line = strings.TrimRight(line, " \t")
-	if line == "" && !timestamps {
-		return "\n"
+// lineToPlain joins parts of a line together and renders them as plain text,
+// optionally with a timestamp prefix, and the Δ column if elapsed times are in
+// use (gap is the time the line took, or noGap if unknown). The output string
+// will have a terminating \n.
+func (s *Screen) lineToPlain(parts []screenLine, timestamps bool, gap time.Duration) string {
+	line := lineText(parts)
+	if col := s.plainGapColumn(gap); col != "" {
+		line = strings.TrimRight(col+" "+line, " \t")
+	}
+	if timestamps {
+		if millis, ok := lineTimestamp(parts); ok {
+			line = s.formatTimestamp(millis, defaultPlainTimestampLayout) + " " + line
+			line = strings.TrimRight(line, " \t")
+		}
 	}
 	return line + "\n"
 }
@@ -1,7 +1,9 @@
 package terminal
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -38,10 +40,108 @@ func TestScreenLineAsHTML_Interleaving(t *testing.T) {
 				t.Fatalf("len(s.screen) = %d, want 1", len(s.screen))
 			}
 
-			got := lineToHTML(s.screen[:1], true)
+			got := s.lineToHTML(s.screen[:1], true, noGap)
 			if diff := cmp.Diff(got, test.want); diff != "" {
-				t.Errorf("lineToHTML(s.screen[:1], true) diff (-got +want):\n%s", diff)
+				t.Errorf("s.lineToHTML(s.screen[:1], true) diff (-got +want):\n%s", diff)
 			}
 		})
 	}
 }
+
+func TestTimestampFormatting(t *testing.T) {
+	melbourne, err := time.LoadLocation("Australia/Melbourne")
+	if err != nil {
+		t.Skipf("time.LoadLocation(Australia/Melbourne) error = %v", err)
+	}
+
+	// 2024-01-02T03:04:05.678Z, then 83.456s later.
+	const input = "\x1b_bk;t=1704164645678\x07one\n\x1b_bk;dt=83456\x07two"
+
+	tests := []struct {
+		name      string
+		opts      []ScreenOption
+		wantHTML  string
+		wantPlain string
+	}{
+		{
+			name: "default",
+			wantHTML: `<time datetime="2024-01-02T03:04:05.678Z">2024-01-02T03:04:05.678Z</time>one` + "\n" +
+				`<time datetime="2024-01-02T03:05:29.134Z">2024-01-02T03:05:29.134Z</time>two`,
+			wantPlain: "2024-01-02T03:04:05Z one\n2024-01-02T03:05:29Z two",
+		},
+		{
+			name: "time only with milliseconds",
+			opts: []ScreenOption{WithTimestampLayout("15:04:05.000")},
+			wantHTML: `<time datetime="2024-01-02T03:04:05.678Z">03:04:05.678</time>one` + "\n" +
+				`<time datetime="2024-01-02T03:05:29.134Z">03:05:29.134</time>two`,
+			wantPlain: "03:04:05.678 one\n03:05:29.134 two",
+		},
+		{
+			name: "time zone",
+			opts: []ScreenOption{WithTimestampLocation(melbourne)},
+			wantHTML: `<time datetime="2024-01-02T03:04:05.678Z">2024-01-02T14:04:05.678+11:00</time>one` + "\n" +
+				`<time datetime="2024-01-02T03:05:29.134Z">2024-01-02T14:05:29.134+11:00</time>two`,
+			wantPlain: "2024-01-02T14:04:05+11:00 one\n2024-01-02T14:05:29+11:00 two",
+		},
+		{
+			name: "time zone and layout",
+			opts: []ScreenOption{WithTimestampLocation(melbourne), WithTimestampLayout(time.RFC3339)},
+			wantHTML: `<time datetime="2024-01-02T03:04:05.678Z">2024-01-02T14:04:05+11:00</time>one` + "\n" +
+				`<time datetime="2024-01-02T03:05:29.134Z">2024-01-02T14:05:29+11:00</time>two`,
+			wantPlain: "2024-01-02T14:04:05+11:00 one\n2024-01-02T14:05:29+11:00 two",
+		},
+		{
+			name: "relative",
+			opts: []ScreenOption{WithRelativeTimestamps(true)},
+			wantHTML: `<time datetime="2024-01-02T03:04:05.678Z">+00:00:00.000</time>one` + "\n" +
+				`<time datetime="2024-01-02T03:05:29.134Z">+00:01:23.456</time>two`,
+			wantPlain: "+00:00:00.000 one\n+00:01:23.456 two",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewScreen(test.opts...)
+			if err != nil {
+				t.Fatalf("NewScreen(...) error = %v", err)
+			}
+			s.Write([]byte(input))
+			if diff := cmp.Diff(s.AsHTML(), test.wantHTML); diff != "" {
+				t.Errorf("AsHTML() diff (-got +want):\n%s", diff)
+			}
+			if diff := cmp.Diff(s.AsPlainTextWithTimestamps(true), test.wantPlain); diff != "" {
+				t.Errorf("AsPlainTextWithTimestamps(true) diff (-got +want):\n%s", diff)
+			}
+
+			// Streaming output should be the same.
+			s, err = NewScreen(append(test.opts, WithMaxSize(0, 1))...)
+			if err != nil {
+				t.Fatalf("NewScreen(..., WithMaxSize(0, 1)) error = %v", err)
+			}
+			var plain strings.Builder
+			s.ScrollOutPlainFunc = func(line string) { plain.WriteString(line) }
+			s.Write([]byte(input))
+			plain.WriteString(s.AsPlainTextWithTimestamps(true))
+			if diff := cmp.Diff(plain.String(), test.wantPlain); diff != "" {
+				t.Errorf("streamed plain text diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFormatRelativeTimestamp(t *testing.T) {
+	tests := []struct {
+		millis int64
+		want   string
+	}{
+		{0, "+00:00:00.000"},
+		{83456, "+00:01:23.456"},
+		{100*3_600_000 + 1, "+100:00:00.001"},
+		{-1500, "-00:00:01.500"},
+	}
+	for _, test := range tests {
+		if got := formatRelativeTimestamp(test.millis); got != test.want {
+			t.Errorf("formatRelativeTimestamp(%d) = %q, want %q", test.millis, got, test.want)
+		}
+	}
+}
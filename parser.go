@@ -1,6 +1,7 @@
 package terminal
 
 import (
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
@@ -14,8 +15,15 @@ const (
 	parserModeCharset
 	parserModeAPC
 	parserModeAPCEsc // within APC and just read an escape
+	parserModeDCS
+	parserModeDCSEsc // within DCS and just read an escape
 )
 
+// The longest device control string read, so that a stray ESC P (which
+// starts one that may never end) can't use unbounded memory, or hold up
+// output for long. It's enough for a large Sixel screenshot.
+const maxDCSLength = 4 << 20
+
 type position struct {
 	x, y int
 }
@@ -34,6 +42,19 @@ type parser struct {
 
 	// Buildkite-specific state
 	lastTimestamp int64
+
+	// The first Buildkite timestamp, for relative timestamps.
+	firstTimestamp    int64
+	hasFirstTimestamp bool
+
+	// Kitty graphics state: the chunked transmission in progress,
+	// transmitted images by id, and the total length of their content.
+	kitty       *kittyTransfer
+	kittyImages map[string]*element
+	kittyStored int
+
+	// The iTerm2 multipart file transfer in progress.
+	iTermFile *iTermTransfer
 }
 
 /*
@@ -54,7 +75,8 @@ type parser struct {
  * 2. For `]` we enter parserModeOSC and look for an operating system command.
  * 3. For `(` or ')' we enter parserModeCharset and look for a character set name.
  * 4. For `_` we enter parserModeAPC and parse the rest of the custom control sequence
- * 5. For `M`, `7`, or `8`, we run an instruction directly (reverse newline,
+ * 5. For `P` we enter parserModeDCS and look for a device control string.
+ * 6. For `M`, `7`, or `8`, we run an instruction directly (reverse newline,
  *    or save/restore cursor).
  *
  * In all cases we start our instruction buffer. The instruction buffer is used
@@ -78,6 +100,11 @@ type parser struct {
  * parserModeAPC is just like parserModeOSC, except the contents should be processed
  * differently.
  *
+ * parserModeDCS is also like parserModeOSC, except that only ESC \ terminates
+ * it. The only device control strings processed are Sixel images. If no
+ * terminator is found within maxDCSLength, we go back to just after the ESC
+ * and return to parserModeNormal, as for an unrecognised escape.
+ *
  * If we're in parserModeCharset we simply discard the next character which would
  * normally designate the character set.
  */
@@ -92,6 +119,11 @@ func (p *parser) parseToScreen(input []byte) {
 		charBytes := p.buffer.slice(p.cursor, min(p.cursor+4, p.buffer.len()))
 		char, charLen := utf8.DecodeRune(charBytes)
 
+		if (p.mode == parserModeDCS || p.mode == parserModeDCSEsc) && p.cursor-p.instructionStartedAt > maxDCSLength {
+			p.abandonDCS()
+			continue
+		}
+
 		switch p.mode {
 		case parserModeEscape:
 			// We've received an escape character but aren't inside an escape sequence yet
@@ -121,6 +153,14 @@ func (p *parser) parseToScreen(input []byte) {
 			// We're inside an APC, and just hit an ESC (which might be ST)
 			p.handleAPCEscape(char)
 
+		case parserModeDCS:
+			// We're inside a device control string, capture until we hit ESC \ (ST)
+			p.handleDeviceControlString(char)
+
+		case parserModeDCSEsc:
+			// We're inside a DCS, and just hit an ESC (which might be ST)
+			p.handleDCSEscape(char)
+
 		case parserModeNormal:
 			// Outside of an escape sequence entirely, normal input
 			p.handleNormal(char)
@@ -189,7 +229,21 @@ func (p *parser) handleOperatingSystemCommand(char rune) {
 // processOperatingSystemCommand processes the contents of the OSC that was just read.
 func (p *parser) processOperatingSystemCommand(end int) {
 	p.mode = parserModeNormal
-	element, err := parseElementSequence(string(p.buffer.slice(p.instructionStartedAt, end)))
+	sequence := string(p.buffer.slice(p.instructionStartedAt, end))
+
+	// Multipart files are only processed once they're complete.
+	handled, file, err := p.handleITermMultipart(sequence)
+	if handled {
+		if err == nil && file == "" {
+			return
+		}
+		sequence = file
+	}
+
+	var element *element
+	if err == nil {
+		element, err = parseElementSequence(sequence)
+	}
 	// Errors are rendered into the screen (see below).
 
 	if element == nil && err == nil {
@@ -197,7 +251,9 @@ func (p *parser) processOperatingSystemCommand(end int) {
 		return
 	}
 
-	ownLine := element == nil || element.elementType == elementImage || element.elementType == elementITermImage
+	ownLine := element == nil || element.elementType == elementImage || element.elementType == elementITermImage ||
+		element.elementType == elementITermFile || element.elementType == elementBlock ||
+		element.elementType == elementCallout
 
 	if ownLine {
 		// Images (or the error encountered) should appear on their own line
@@ -219,6 +275,7 @@ func (p *parser) processOperatingSystemCommand(end int) {
 		// Instead of appending an "element" node, store the URL to apply like a
 		// colour. If the URL is empty, the text is no longer linked.
 		p.screen.urlBrush = element.url
+		p.screen.linkIDBrush = element.linkID
 		p.screen.style.setHyperlink(element.url != "")
 		return
 	}
@@ -230,6 +287,28 @@ func (p *parser) processOperatingSystemCommand(end int) {
 	}
 }
 
+// displayError appends an error (after prefix) on its own line, in place of
+// an element.
+func (p *parser) displayError(prefix string, err error) {
+	if p.screen.x != 0 {
+		p.screen.newLine()
+	}
+	p.screen.currentLine().clear(screenStartOfLine, screenEndOfLine)
+	p.screen.appendMany([]rune(prefix))
+	p.screen.appendMany([]rune(err.Error()))
+	p.screen.newLine()
+}
+
+// displayImage appends an image element on its own line.
+func (p *parser) displayImage(elem *element) {
+	if p.screen.x != 0 {
+		p.screen.newLine()
+	}
+	p.screen.currentLine().clear(screenStartOfLine, screenEndOfLine)
+	p.screen.appendElement(elem)
+	p.screen.newLine()
+}
+
 // handleAPCEscape is called for the character after an ESC when reading an APC.
 // It either returns to APC mode, or terminates the APC and processes it.
 func (p *parser) handleAPCEscape(char rune) {
@@ -282,7 +361,20 @@ func (p *parser) processApplicationProgramCommand(end int) {
 	p.mode = parserModeNormal
 	sequence := string(p.buffer.slice(p.instructionStartedAt, end))
 
-	// this might be a Buildkite Application Program Command sequence...
+	// A registered APCHandler takes precedence over Kitty graphics, whose
+	// APCs can look like they're in the "G" namespace...
+	if namespace, _, _ := strings.Cut(sequence, ";"); p.screen.apcHandlers[namespace] != nil {
+		p.handleRegisteredAPC(sequence)
+		return
+	}
+
+	// ...so this might be a Kitty graphics protocol image...
+	if isKittyGraphics(sequence) {
+		p.handleKittyGraphics(sequence[1:])
+		return
+	}
+
+	// ...or a Buildkite Application Program Command sequence...
 	data, err := p.parseBuildkiteAPC(sequence)
 	if err != nil {
 		p.screen.appendMany([]rune("*** Error parsing Buildkite APC ANSI escape sequence: "))
@@ -291,11 +383,65 @@ func (p *parser) processApplicationProgramCommand(end int) {
 	}
 
 	if data == nil {
+		// ...or one handled by a registered APCHandler.
+		p.handleRegisteredAPC(sequence)
 		return
 	}
 	p.screen.setLineMetadata(bkNamespace, data)
 }
 
+// handleDeviceControlString is called for each character consumed while in
+// parserModeDCS. It does nothing until the DCS is terminated with ESC \ (ST).
+func (p *parser) handleDeviceControlString(char rune) {
+	if char == '\x1b' {
+		// Next char _could_ be \ which makes the combination ST
+		p.mode = parserModeDCSEsc
+	}
+}
+
+// handleDCSEscape is called for the character after an ESC when reading a DCS.
+// It either returns to DCS mode, or terminates the DCS and processes it.
+func (p *parser) handleDCSEscape(char rune) {
+	switch char {
+	case '\\': // ESC + \ = string terminator
+		// Don't include the ESC in the DCS contents.
+		p.processDeviceControlString(p.cursor - 1)
+
+	case '\x1b':
+		// Another ESC, which could also start ST.
+
+	default:
+		// DCS continues...
+		p.mode = parserModeDCS
+	}
+}
+
+// processDeviceControlString processes the contents of the DCS that was just
+// read. Only Sixel images are rendered; other device control strings (such as
+// terminal queries) are discarded.
+func (p *parser) processDeviceControlString(end int) {
+	p.mode = parserModeNormal
+	sequence := string(p.buffer.slice(p.instructionStartedAt, end))
+	if !isSixel(sequence) {
+		return
+	}
+
+	element, err := sixelImage(sequence, p.screen.imagePolicy.MaxSize)
+	if err != nil {
+		p.displayError("*** Error parsing Sixel DCS ANSI escape sequence: ", err)
+		return
+	}
+	p.displayImage(element)
+}
+
+// abandonDCS is called when a DCS is longer than maxDCSLength. It's most
+// likely a stray ESC P rather than a DCS, so like other unrecognised escapes,
+// the ESC is dropped and everything after it is parsed as normal text.
+func (p *parser) abandonDCS() {
+	p.cursor = p.escapeStartedAt + utf8.RuneLen('\x1b')
+	p.mode = parserModeNormal
+}
+
 // handleControlSequence is called for each character consumed while in
 // parserModeControl.
 func (p *parser) handleControlSequence(char rune) {
@@ -364,6 +510,10 @@ func (p *parser) handleEscape(char rune) {
 		p.instructionStartedAt = p.cursor + utf8.RuneLen('[')
 		p.mode = parserModeAPC
 
+	case 'P':
+		p.instructionStartedAt = p.cursor + utf8.RuneLen('P')
+		p.mode = parserModeDCS
+
 	case 'M':
 		p.screen.revNewLine()
 		p.mode = parserModeNormal
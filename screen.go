@@ -6,6 +6,7 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -24,8 +25,9 @@ type Screen struct {
 	// Current style
 	style style
 
-	// Current URL for OSC 8 (iTerm-style) hyperlinking
-	urlBrush string
+	// Current URL and link id for OSC 8 (iTerm-style) hyperlinking
+	urlBrush    string
+	linkIDBrush string
 
 	// Parser to use for streaming processing
 	parser parser
@@ -59,10 +61,67 @@ type Screen struct {
 	// If both ScrollOutFunc and ScrollOutPlainFunc are set, only ScrollOutPlainFunc is used.
 	ScrollOutPlainFunc func(linePlain string)
 
+	// Optional callback. If not nil, it's called with errors storing images
+	// in the asset sink (see WithAssetSink). The images are replaced with a
+	// placeholder that doesn't include the error.
+	AssetErrorFunc func(err error)
+
 	// Timestamps controls whether timestamps are included in output.
 	// Defaults to true (timestamps included).
 	Timestamps bool
 
+	// Whether to render Buildkite log groups (see WithGroups).
+	groups bool
+
+	// The group that the next line scrolled out of the buffer belongs to.
+	group groupState
+
+	// Sections of the lines scrolled out of the buffer so far.
+	sections sectionState
+
+	// Metadata keys to render as attributes (see WithMetadataAttributes).
+	metadataAttrs []string
+
+	// Handlers for APC namespaces other than bk, by namespace.
+	apcHandlers map[string]APCHandler
+
+	// How timestamps are shown (see WithTimestampLayout,
+	// WithTimestampLocation, and WithRelativeTimestamps).
+	timestampLayout    string
+	timestampLocation  *time.Location
+	relativeTimestamps bool
+
+	// Elapsed times and slow line highlighting (see WithElapsed and
+	// WithSlowThreshold), and the line held back from scrolling out until
+	// its elapsed time is known.
+	elapsed       bool
+	slowThreshold time.Duration
+	held          []screenLine
+
+	// The time range of lines to output (see WithTimeRange), and the
+	// timestamp inherited by the next line scrolled out of the buffer.
+	since, until TimeBound
+	rangeState   timeRangeState
+
+	// Which URLs can be used in links and images (see WithURLPolicy).
+	urlPolicy URLPolicy
+
+	// Rules for turning text into links (see WithLinkifier).
+	linkRules []LinkRule
+
+	// Chooses the attributes of each link (see WithLinkAttributes).
+	linkAttrs func(url string) LinkAttributes
+
+	// How images are rendered (see WithImagePolicy).
+	imagePolicy ImagePolicy
+
+	// Where inline images are stored, if not in the output (see
+	// WithAssetSink).
+	assetSink AssetSink
+
+	// The URL template for artifact elements (see WithArtifactURL).
+	artifactURL string
+
 	// Processing statistics
 	LinesScrolledOut int // count of lines that scrolled off the top
 	CursorUpOOB      int // count of times ESC [A or ESC [F tried to move y < 0
@@ -94,6 +153,79 @@ func WithMaxSize(maxCols, maxLines int) ScreenOption {
 	}
 }
 
+// WithGroups controls rendering of Buildkite log groups. When enabled, a line
+// beginning with "--- ", "+++ " or "~~~ " starts a new group, which is
+// rendered in HTML as a <details> element with the line as its <summary>.
+// Groups started with "+++ " are expanded. Each group ends at the next group
+// header, or the end of the output, where its duration (according to the
+// Buildkite timestamps on its lines) is also rendered.
+// Groups are disabled by default.
+func WithGroups(enabled bool) ScreenOption {
+	return func(s *Screen) error {
+		s.groups = enabled
+		return nil
+	}
+}
+
+// WithMetadataAttributes renders line metadata as data-<namespace>-<key>
+// attributes. Lines with any of the selected keys are wrapped in a
+// <span class="term-line"> element carrying the attributes.
+//
+// Keys select Buildkite metadata (the key=value pairs in
+// "ESC _ bk;key=value BEL" sequences), rendered as data-bk-<key>. Keys of the
+// form "namespace.key" select metadata in other namespaces (see
+// RegisterAPCHandler). The key "*" (or "namespace.*") selects every key in
+// the namespace, except the Buildkite timestamp t, which is rendered as a
+// <time> element instead. Namespaces and keys may only contain ASCII letters,
+// digits, '-' and '_'. Attribute names are lower case, so keys differing only
+// in case are best avoided.
+func WithMetadataAttributes(keys ...string) ScreenOption {
+	return func(s *Screen) error {
+		for _, k := range keys {
+			ns, key := splitMetadataAttribute(k)
+			if !isAPCNamespace(ns) || (key != "*" && !isMetadataAttributeKey(key)) {
+				return fmt.Errorf("invalid metadata attribute key %q", k)
+			}
+		}
+		s.metadataAttrs = keys
+		return nil
+	}
+}
+
+// WithTimestampLayout sets the layout (as used by time.Time.Format) of the
+// visible text of timestamps, in both HTML and plain text output. By default,
+// HTML output shows milliseconds (e.g. 2006-01-02T15:04:05.999Z) and plain
+// text output does not (e.g. 2006-01-02T15:04:05Z). In HTML, the datetime
+// attribute of the <time> element is always in UTC, to millisecond precision.
+func WithTimestampLayout(layout string) ScreenOption {
+	return func(s *Screen) error {
+		s.timestampLayout = layout
+		return nil
+	}
+}
+
+// WithTimestampLocation sets the time zone that timestamps are shown in.
+// The default is UTC.
+func WithTimestampLocation(loc *time.Location) ScreenOption {
+	return func(s *Screen) error {
+		if loc == nil {
+			return fmt.Errorf("nil timestamp location")
+		}
+		s.timestampLocation = loc
+		return nil
+	}
+}
+
+// WithRelativeTimestamps controls showing timestamps relative to the first
+// Buildkite timestamp written to the screen, as +hh:mm:ss.mmm. When enabled,
+// the timestamp layout and location are not used.
+func WithRelativeTimestamps(enabled bool) ScreenOption {
+	return func(s *Screen) error {
+		s.relativeTimestamps = enabled
+		return nil
+	}
+}
+
 // NewScreen creates a new screen with various options.
 func NewScreen(opts ...ScreenOption) (*Screen, error) {
 	s := &Screen{
@@ -251,6 +383,7 @@ func (s *Screen) currentLineForWriting() *screenLine {
 			newLine := screenLine{
 				nodes:   nodes,
 				newline: true,
+				dirty:   true,
 			}
 			s.screen = append(s.screen, newLine)
 			if s.y >= s.lines {
@@ -266,9 +399,11 @@ func (s *Screen) currentLineForWriting() *screenLine {
 		// maxLines is in effect, and adding a new line would make the screen
 		// larger than maxLines.
 		// Pass the whole line being scrolled out to ScrollOutFunc if available,
-		// otherwise just scroll out 1 line to nowhere.
+		// otherwise just scroll out 1 line to nowhere. (Unless groups, elapsed
+		// times or a time range are in use, in which case whole lines are
+		// needed to keep track of them.)
 		scrollOutTo := 1
-		if s.ScrollOutPlainFunc != nil || s.ScrollOutFunc != nil {
+		if s.ScrollOutPlainFunc != nil || s.ScrollOutFunc != nil || s.groups || s.lineGaps() || s.timeRange() {
 			// Whole lines need to be passed to the callback. Find the end of
 			// the line (the screen line with newline = true).
 			// The majority of the time this will just be the first screen line.
@@ -288,12 +423,7 @@ func (s *Screen) currentLineForWriting() *screenLine {
 					break
 				}
 			}
-			if s.ScrollOutPlainFunc != nil {
-				s.ScrollOutPlainFunc(lineToPlain(s.screen[:scrollOutTo], s.Timestamps))
-			}
-			if s.ScrollOutFunc != nil {
-				s.ScrollOutFunc(lineToHTML(s.screen[:scrollOutTo], s.Timestamps))
-			}
+			s.scrollOut(s.screen[:scrollOutTo])
 		}
 		for i := range scrollOutTo {
 			s.nodeRecycling = append(s.nodeRecycling, s.screen[i].nodes[:0])
@@ -311,9 +441,13 @@ func (s *Screen) currentLineForWriting() *screenLine {
 			// out a line that consisted of no screenlines.
 			nodes = make([]node, 0, s.cols)
 		}
+		// Lines are identified by their index counting from the first line
+		// ever written, so scrolling out doesn't change the lines that remain.
+		// Only the new line needs to be reported.
 		newLine := screenLine{
 			nodes:   nodes,
 			newline: true,
+			dirty:   true,
 		}
 		s.screen = append(s.screen[scrollOutTo:], newLine)
 
@@ -324,6 +458,46 @@ func (s *Screen) currentLineForWriting() *screenLine {
 	return s.currentLine()
 }
 
+// scrollOut passes a whole line that is leaving the buffer to the scroll out
+// callbacks, and updates the state that carries over to the following lines.
+func (s *Screen) scrollOut(parts []screenLine) {
+	if s.timeRange() && !s.inTimeRange(&s.rangeState, parts) {
+		if s.groups {
+			// The held line comes before this one in its group.
+			if s.held != nil {
+				s.scrollOutLine(s.held, noGap)
+				s.held = nil
+			}
+			s.group.skip(parts, func() string { return s.lineToHTML(parts, s.Timestamps, noGap) })
+			s.sections.add(parts)
+		}
+		return
+	}
+	if !s.lineGaps() {
+		s.scrollOutLine(parts, noGap)
+		return
+	}
+	if held := s.holdLine(parts); held != nil {
+		s.scrollOutLine(held, lineGap(held, parts))
+	}
+}
+
+// scrollOutLine passes a whole line, which took gap, to the scroll out
+// callbacks.
+func (s *Screen) scrollOutLine(parts []screenLine, gap time.Duration) {
+	var change groupChange
+	if s.groups {
+		change = s.group.advance(parts)
+		s.sections.add(parts)
+	}
+	if s.ScrollOutPlainFunc != nil {
+		s.ScrollOutPlainFunc(s.lineToPlain(parts, s.Timestamps, gap))
+	}
+	if s.ScrollOutFunc != nil {
+		s.ScrollOutFunc(change.wrapHTML(s.lineToHTML(parts, s.Timestamps, gap)))
+	}
+}
+
 // Write a character to the screen's current X&Y, along with the current screen style
 func (s *Screen) write(data rune) {
 	line := s.currentLineForWriting()
@@ -335,6 +509,14 @@ func (s *Screen) write(data rune) {
 			line.hyperlinks = make(map[int]string)
 		}
 		line.hyperlinks[s.x] = s.urlBrush
+		if s.linkIDBrush != "" {
+			if line.linkIDs == nil {
+				line.linkIDs = make(map[int]string)
+			}
+			line.linkIDs[s.x] = s.linkIDBrush
+		} else {
+			delete(line.linkIDs, s.x)
+		}
 	}
 
 	s.x++
@@ -367,6 +549,7 @@ func (s *Screen) appendElement(i *element) {
 // metadata for the current line, overwriting data when keys collide.
 func (s *Screen) setLineMetadata(namespace string, data map[string]string) {
 	line := s.currentLineForWriting()
+	line.dirty = true
 	if line.metadata == nil {
 		line.metadata = map[string]map[string]string{
 			namespace: data,
@@ -542,15 +725,29 @@ func (s *Screen) Write(input []byte) (int, error) {
 	return len(input), nil
 }
 
-// AsHTML returns the contents of the current screen buffer as HTML with timestamps.
-func (s *Screen) AsHTML() string {
-	return s.AsHTMLWithTimestamps(true)
+// wholeLines splits the screen buffer into whole lines (each ending with a
+// screen line with newline = true, apart from the last), preceded by the line
+// held back for elapsed times, if any. Lines outside the time range (see
+// WithTimeRange) are left out.
+func (s *Screen) wholeLines() [][]screenLine {
+	all, inRange := s.allWholeLines()
+	var lines [][]screenLine
+	for i, parts := range all {
+		if inRange[i] {
+			lines = append(lines, parts)
+		}
+	}
+	return lines
 }
 
-// AsHTMLWithTimestamps returns the contents of the current screen buffer as HTML.
-func (s *Screen) AsHTMLWithTimestamps(timestamps bool) string {
-	var sb strings.Builder
-
+// allWholeLines is like wholeLines, but includes the lines outside the time
+// range, and reports which lines are in it.
+func (s *Screen) allWholeLines() (lines [][]screenLine, inRange []bool) {
+	if s.held != nil {
+		lines = append(lines, s.held)
+		inRange = append(inRange, true)
+	}
+	rangeState := s.rangeState
 	screen := s.screen
 	for len(screen) > 0 {
 		// Find lineEnd of a line, or failing that, go to the end of the screen.
@@ -561,9 +758,54 @@ func (s *Screen) AsHTMLWithTimestamps(timestamps bool) string {
 				break
 			}
 		}
-		sb.WriteString(lineToHTML(screen[:lineEnd], timestamps))
+		lines = append(lines, screen[:lineEnd])
+		inRange = append(inRange, !s.timeRange() || s.inTimeRange(&rangeState, screen[:lineEnd]))
 		screen = screen[lineEnd:]
 	}
+	return lines, inRange
+}
+
+// AsHTML returns the contents of the current screen buffer as HTML with timestamps.
+func (s *Screen) AsHTML() string {
+	return s.AsHTMLWithTimestamps(true)
+}
+
+// AsHTMLWithTimestamps returns the contents of the current screen buffer as HTML.
+func (s *Screen) AsHTMLWithTimestamps(timestamps bool) string {
+	var sb strings.Builder
+
+	// Continue from the group that scrolled-out lines left off in, without
+	// changing it.
+	group := s.group
+	all, inRange := s.allWholeLines()
+	var lines [][]screenLine
+	for i, parts := range all {
+		if inRange[i] {
+			lines = append(lines, parts)
+		}
+	}
+	gaps := s.gaps(lines)
+	n := 0
+	for i, parts := range all {
+		if !inRange[i] {
+			if s.groups {
+				group.skip(parts, func() string { return s.lineToHTML(parts, timestamps, noGap) })
+			}
+			continue
+		}
+		line := s.lineToHTML(parts, timestamps, gaps[n])
+		n++
+		if s.groups {
+			line = group.advance(parts).wrapHTML(line)
+		}
+		sb.WriteString(line)
+	}
+
+	// The last group is closed after the final newline, so that output is the
+	// same whether or not the last line has scrolled out.
+	if s.groups {
+		sb.WriteString(group.finish().closeHTML())
+	}
 
 	// For backwards compatibility the final newline is trimmed.
 	return strings.TrimSuffix(sb.String(), "\n")
@@ -571,7 +813,14 @@ func (s *Screen) AsHTMLWithTimestamps(timestamps bool) string {
 
 // AsPlainText renders the screen without any ANSI style etc.
 func (s *Screen) AsPlainText() string {
+	if s.timeRange() {
+		return s.AsPlainTextWithTimestamps(false)
+	}
+
 	var sb strings.Builder
+	for _, line := range s.held {
+		sb.WriteString(line.asPlain())
+	}
 	for _, line := range s.screen {
 		sb.WriteString(line.asPlain())
 	}
@@ -583,23 +832,92 @@ func (s *Screen) AsPlainText() string {
 // AsPlainTextWithTimestamps renders the screen as plain text, optionally
 // with UTC timestamp prefixes.
 func (s *Screen) AsPlainTextWithTimestamps(timestamps bool) string {
-	if !timestamps {
+	if !timestamps && !s.lineGaps() && !s.timeRange() {
 		return s.AsPlainText()
 	}
 
 	var sb strings.Builder
-	for i := 0; i < len(s.screen); {
-		// Find the end of this logical line.
-		lineEnd := i + 1
-		for lineEnd < len(s.screen) && !s.screen[lineEnd-1].newline {
-			lineEnd++
-		}
-		sb.WriteString(lineToPlain(s.screen[i:lineEnd], true))
-		i = lineEnd
+	lines := s.wholeLines()
+	gaps := s.gaps(lines)
+	for i, parts := range lines {
+		sb.WriteString(s.lineToPlain(parts, timestamps, gaps[i]))
 	}
 	return strings.TrimSuffix(sb.String(), "\n")
 }
 
+// Sections summarises the Buildkite log groups in everything written to the
+// screen so far, including lines that have scrolled out of the buffer. The
+// lines before the first group header (if any) are the first section.
+// Sections returns nil unless groups are enabled (see WithGroups).
+func (s *Screen) Sections() []Section {
+	if !s.groups {
+		return nil
+	}
+	sections := s.sections.clone()
+	all, _ := s.allWholeLines()
+	for _, parts := range all {
+		sections.add(parts)
+	}
+	return sections.sections()
+}
+
+// LineUpdate describes a screen line that has changed.
+type LineUpdate struct {
+	// Index is the position of the screen line, counting every screen line
+	// that has been in the buffer from 0 (including lines that have since
+	// scrolled out). A long line that wraps takes up several screen lines, and
+	// so several indexes. A line's index doesn't change as other lines scroll
+	// out, including lines held back to measure elapsed times (see
+	// WithElapsed), which have already left the buffer.
+	Index int
+
+	// HTML is the line contents rendered as HTML, without a trailing newline.
+	HTML string
+}
+
+// FlushChanges returns the lines that have been written to, cleared, or
+// added since the previous call to FlushChanges (or since the screen was
+// created), in order.
+//
+// Unlike AsHTML, each line is rendered on its own: a long line that wraps
+// onto following lines is reported as multiple lines. Lines that scroll out
+// of the buffer before being flushed are not reported (see ScrollOutFunc).
+func (s *Screen) FlushChanges() []LineUpdate {
+	var updates []LineUpdate
+	for i := range s.screen {
+		line := &s.screen[i]
+		if !line.dirty {
+			continue
+		}
+		line.dirty = false
+		gap := noGap
+		if s.lineGaps() && i+1 < len(s.screen) {
+			gap = lineGap(s.screen[i:i+1], s.screen[i+1:i+2])
+		}
+		updates = append(updates, LineUpdate{
+			Index: s.LinesScrolledOut + i,
+			HTML:  strings.TrimSuffix(s.lineToHTML(s.screen[i:i+1], s.Timestamps, gap), "\n"),
+		})
+	}
+	return updates
+}
+
+// LineMetadata returns a copy of the metadata for a line, by namespace (e.g.
+// "bk") then key. index counts lines in the same way as LineUpdate.Index. It
+// returns nil if the line has no metadata, or is not in the buffer (either
+// not yet written, or scrolled out).
+func (s *Screen) LineMetadata(index int) map[string]map[string]string {
+	i := index - s.LinesScrolledOut
+	if i < 0 || i >= len(s.screen) || len(s.screen[i].metadata) == 0 {
+		return nil
+	}
+	md := make(map[string]map[string]string, len(s.screen[i].metadata))
+	for ns, data := range s.screen[i].metadata {
+		md[ns] = maps.Clone(data)
+	}
+	return md
+}
+
 func (s *Screen) newLine() {
 	// Do the carriage return first to ensure that currentLineForWriting can't
 	// give us the next line if the cursor was placed past the end of the line.
@@ -651,6 +969,14 @@ type screenLine struct {
 	// So a map is used for sparse storage, only lazily created when text with
 	// a link style is written.
 	hyperlinks map[int]string
+
+	// linkIDs stores the id parameters of OSC 8 links by X position, for the
+	// links that have them.
+	linkIDs map[int]string
+
+	// dirty is true if the line has changed since it was last reported by
+	// FlushChanges.
+	dirty bool
 }
 
 func (l *screenLine) clearAll() {
@@ -659,6 +985,7 @@ func (l *screenLine) clearAll() {
 	}
 	l.nodes = l.nodes[:0]
 	l.newline = true
+	l.dirty = true
 }
 
 // clear clears part (or all) of a line. The range to clear is inclusive
@@ -681,6 +1008,8 @@ func (l *screenLine) clear(xStart, xEnd int) {
 		return
 	}
 
+	l.dirty = true
+
 	if xEnd >= len(l.nodes)-1 {
 		// Clear from start to end of the line
 		l.nodes = l.nodes[:xStart]
@@ -698,4 +1027,5 @@ func (l *screenLine) writeNode(x int, n node) {
 		l.nodes = append(l.nodes, emptyNode)
 	}
 	l.nodes[x] = n
+	l.dirty = true
 }
@@ -60,3 +60,68 @@ func TestCurrentLineForWriting(t *testing.T) {
 		})
 	}
 }
+
+func TestFlushChanges(t *testing.T) {
+	s, err := NewScreen(WithMaxSize(0, 3), WithSize(80, 3))
+	if err != nil {
+		t.Fatalf("NewScreen(WithMaxSize(0, 3), WithSize(80, 3)) error = %v", err)
+	}
+
+	s.Write([]byte("one\ntwo\nthree"))
+	want := []LineUpdate{
+		{Index: 0, HTML: "one"},
+		{Index: 1, HTML: "two"},
+		{Index: 2, HTML: "three"},
+	}
+	if diff := cmp.Diff(s.FlushChanges(), want); diff != "" {
+		t.Errorf("first FlushChanges() diff (-got +want):\n%s", diff)
+	}
+
+	if got := s.FlushChanges(); len(got) != 0 {
+		t.Errorf("FlushChanges() with no writes = %v, want no updates", got)
+	}
+
+	// Rewrite the middle line, then scroll out the first.
+	s.Write([]byte("\x1b[A\r\x1b[2K\x1b[32mTWO\x1b[0m\x1b[B\nfour"))
+	want = []LineUpdate{
+		{Index: 1, HTML: `<span class="term-fg32">TWO</span>`},
+		{Index: 3, HTML: "four"},
+	}
+	if diff := cmp.Diff(s.FlushChanges(), want); diff != "" {
+		t.Errorf("second FlushChanges() diff (-got +want):\n%s", diff)
+	}
+
+	// Clearing the window marks every line.
+	s.Write([]byte("\x1b[2J"))
+	want = []LineUpdate{
+		{Index: 1, HTML: "&nbsp;"},
+		{Index: 2, HTML: "&nbsp;"},
+		{Index: 3, HTML: "&nbsp;"},
+	}
+	if diff := cmp.Diff(s.FlushChanges(), want); diff != "" {
+		t.Errorf("third FlushChanges() diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestFlushChangesIndexes(t *testing.T) {
+	// Indexes count screen lines, so a wrapped line takes two. Lines held
+	// back for elapsed times don't change them.
+	want := [][]LineUpdate{
+		{{Index: 0, HTML: "one"}},
+		{{Index: 1, HTML: "two"}},
+		{{Index: 2, HTML: "wrap"}, {Index: 3, HTML: "ped"}},
+		{{Index: 4, HTML: "five"}},
+	}
+	for _, elapsed := range []bool{false, true} {
+		s, err := NewScreen(WithMaxSize(0, 3), WithSize(4, 3), WithElapsed(elapsed))
+		if err != nil {
+			t.Fatalf("NewScreen() error = %v", err)
+		}
+		for i, input := range []string{"one\n", "two\n", "wrapped\n", "five"} {
+			s.Write([]byte(input))
+			if diff := cmp.Diff(s.FlushChanges(), want[i]); diff != "" {
+				t.Errorf("elapsed = %t: FlushChanges() after writing %q diff (-got +want):\n%s", elapsed, input, diff)
+			}
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package terminal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Sixel images are sent in DCS sequences:
+//
+//	ESC P p1 ; p2 ; p3 q DATA ESC \
+//
+// DATA draws the image in bands six pixels high. Each character from ? to ~
+// is a column of six pixels (the bits of the character minus ?), drawn in the
+// current colour. The other commands are:
+//
+//	"Pan;Pad;Ph;Pv  raster attributes (the last two are the image size)
+//	#Pc             select colour register Pc
+//	#Pc;Pu;Px;Py;Pz define colour register Pc (Pu is 1 for HLS, 2 for RGB)
+//	!Pn c           repeat sixel character c Pn times
+//	$               return to the start of the band
+//	-               move to the start of the next band
+//
+// Pixels that aren't drawn are transparent.
+
+// The largest Sixel image, in pixels each way, so that an image can't use
+// unbounded memory. Anything drawn beyond it is cropped.
+const maxSixelSize = 4096
+
+// sixelPalette is the default colour palette (the VT340's).
+var sixelPalette = [16]color.NRGBA{
+	{0, 0, 0, 255},
+	{51, 51, 204, 255},
+	{204, 36, 36, 255},
+	{51, 204, 51, 255},
+	{204, 51, 204, 255},
+	{51, 204, 204, 255},
+	{204, 204, 51, 255},
+	{120, 120, 120, 255},
+	{69, 69, 69, 255},
+	{87, 87, 153, 255},
+	{153, 69, 69, 255},
+	{87, 153, 87, 255},
+	{153, 87, 153, 255},
+	{87, 153, 153, 255},
+	{153, 153, 87, 255},
+	{204, 204, 204, 255},
+}
+
+// isSixel reports whether a DCS sequence (after the ESC P) is a Sixel image:
+// optional numeric parameters, followed by q.
+func isSixel(sequence string) bool {
+	params, _, ok := strings.Cut(sequence, "q")
+	return ok && strings.Trim(params, "0123456789;") == ""
+}
+
+// sixelImage decodes a Sixel image (a DCS sequence, after the ESC P), and
+// returns it as an image element. If maxSize is positive, images larger than
+// that many bytes (decoded, as NRGBA pixels) are rejected.
+func sixelImage(sequence string, maxSize int) (*element, error) {
+	_, data, _ := strings.Cut(sequence, "q")
+	img, err := decodeSixel(data, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("encoding PNG: %w", err)
+	}
+	return &element{
+		elementType: elementITermImage,
+		contentType: "image/png",
+		content:     base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+// sixelDecoder is the state of a Sixel image being decoded.
+type sixelDecoder struct {
+	palette [256]color.NRGBA
+	colour  color.NRGBA
+	x, y    int // y is the top of the current band
+
+	// The drawn pixels, by row, as NRGBA bytes. Rows are only as long as
+	// needed.
+	rows [][]byte
+
+	// The size from the raster attributes, if any.
+	width, height int
+
+	// The size of the area that sixels (including blank ones) have been
+	// drawn in. The image is no bigger than this, whatever the raster
+	// attributes say, so a few bytes can't make a huge image.
+	drawnWidth, drawnHeight int
+
+	// The largest image, in NRGBA bytes, or 0 for no limit.
+	maxSize int
+}
+
+var errSixelTooLarge = errors.New("image too large")
+
+// decodeSixel decodes Sixel data (after the q). If maxSize is positive,
+// images larger than that many bytes (as NRGBA pixels) are rejected before
+// they're allocated.
+func decodeSixel(data string, maxSize int) (*image.NRGBA, error) {
+	d := &sixelDecoder{maxSize: maxSize}
+	copy(d.palette[:], sixelPalette[:])
+	d.colour = d.palette[0]
+
+	for i := 0; i < len(data); {
+		c := data[i]
+		i++
+		switch {
+		case c >= '?' && c <= '~':
+			if err := d.draw(c, 1); err != nil {
+				return nil, err
+			}
+
+		case c == '!':
+			var args []int
+			args, i = sixelArgs(data, i)
+			if i >= len(data) || len(args) == 0 {
+				return nil, errors.New("incomplete repeat")
+			}
+			if err := d.draw(data[i], args[0]); err != nil {
+				return nil, err
+			}
+			i++
+
+		case c == '#':
+			var args []int
+			args, i = sixelArgs(data, i)
+			if len(args) == 0 {
+				continue
+			}
+			reg := args[0] & 0xff
+			if len(args) >= 5 {
+				d.palette[reg] = sixelColour(args[1], args[2], args[3], args[4])
+			}
+			d.colour = d.palette[reg]
+
+		case c == '"':
+			var args []int
+			args, i = sixelArgs(data, i)
+			if len(args) >= 4 {
+				d.width, d.height = min(args[2], maxSixelSize), min(args[3], maxSixelSize)
+			}
+
+		case c == '$':
+			d.x = 0
+
+		case c == '-':
+			d.x = 0
+			d.y += 6
+		}
+		// Anything else (such as whitespace) is ignored.
+	}
+
+	if d.drawnWidth == 0 {
+		return nil, errors.New("image is empty")
+	}
+	w := min(d.width, d.drawnWidth)
+	h := max(min(d.height, d.drawnHeight), len(d.rows))
+	for _, row := range d.rows {
+		w = max(w, len(row)/4)
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y, row := range d.rows {
+		copy(img.Pix[y*img.Stride:], row)
+	}
+	return img, nil
+}
+
+// sixelArgs parses the numeric arguments starting at data[i], returning them
+// and the index after them. Empty arguments are 0.
+func sixelArgs(data string, i int) ([]int, int) {
+	start := i
+	for i < len(data) && (data[i] >= '0' && data[i] <= '9' || data[i] == ';') {
+		i++
+	}
+	if i == start {
+		return nil, i
+	}
+	var args []int
+	for _, a := range strings.Split(data[start:i], ";") {
+		n, _ := strconv.Atoi(a)
+		args = append(args, min(n, math.MaxInt32))
+	}
+	return args, i
+}
+
+// draw draws a sixel character n times (at least once) at the current
+// position.
+func (d *sixelDecoder) draw(c byte, n int) error {
+	if c < '?' || c > '~' {
+		return nil
+	}
+	bits := c - '?'
+	n = max(0, min(max(n, 1), maxSixelSize-d.x))
+	if n == 0 || d.y >= maxSixelSize {
+		return nil
+	}
+	w, h := max(d.drawnWidth, d.x+n), max(d.drawnHeight, min(d.y+6, maxSixelSize))
+	if d.maxSize > 0 && w*h*4 > d.maxSize {
+		return errSixelTooLarge
+	}
+	d.drawnWidth, d.drawnHeight = w, h
+	if bits != 0 {
+		for b := range 6 {
+			y := d.y + b
+			if bits&(1<<b) == 0 || y >= maxSixelSize {
+				continue
+			}
+			for len(d.rows) <= y {
+				d.rows = append(d.rows, nil)
+			}
+			row := d.rows[y]
+			if end := (d.x + n) * 4; len(row) < end {
+				row = append(row, make([]byte, end-len(row))...)
+			}
+			for x := d.x; x < d.x+n; x++ {
+				copy(row[x*4:], []byte{d.colour.R, d.colour.G, d.colour.B, d.colour.A})
+			}
+			d.rows[y] = row
+		}
+	}
+	d.x += n
+	return nil
+}
+
+// sixelColour returns the colour defined by a colour introducer: RGB
+// percentages if pu is 2, or HLS if pu is 1.
+func sixelColour(pu, px, py, pz int) color.NRGBA {
+	pc := func(v int) uint8 { return uint8(min(v, 100) * 255 / 100) }
+	if pu != 1 {
+		return color.NRGBA{pc(px), pc(py), pc(pz), 255}
+	}
+
+	// Sixel hues start at blue, rather than red.
+	h := float64((px+240)%360) / 360
+	l, s := float64(min(py, 100))/100, float64(min(pz, 100))/100
+	if s == 0 {
+		return color.NRGBA{pc(py), pc(py), pc(py), 255}
+	}
+	q := l + s - l*s
+	if l < 0.5 {
+		q = l * (1 + s)
+	}
+	p := 2*l - q
+	hue := func(t float64) uint8 {
+		t -= math.Floor(t)
+		var v float64
+		switch {
+		case t < 1.0/6:
+			v = p + (q-p)*6*t
+		case t < 1.0/2:
+			v = q
+		case t < 2.0/3:
+			v = p + (q-p)*(2.0/3-t)*6
+		default:
+			v = p
+		}
+		return uint8(math.Round(v * 255))
+	}
+	return color.NRGBA{hue(h + 1.0/3), hue(h), hue(h - 1.0/3), 255}
+}
@@ -0,0 +1,181 @@
+package terminal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDecodeSixel(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+	none := color.NRGBA{}
+
+	tests := []struct {
+		name string
+		data string
+		want [][]color.NRGBA
+	}{
+		{
+			name: "RGB colours and repeats",
+			// #1 is red, #2 is blue. Draw 2 columns of the top pixel in red,
+			// then go back and draw the second pixel of the first column in
+			// blue.
+			data: "#1;2;100;0;0#2;2;0;0;100#1!2@$#2A",
+			want: [][]color.NRGBA{
+				{red, red},
+				{blue, none},
+			},
+		},
+		{
+			name: "HLS colours and bands",
+			// Hue 120 is red, and hue 0 is blue.
+			data: "#1;1;120;50;100#2;1;0;50;100#1@-#2@",
+			want: [][]color.NRGBA{
+				{red}, {none}, {none}, {none}, {none}, {none},
+				{blue},
+			},
+		},
+		{
+			name: "raster attributes set the size",
+			data: "\"1;1;3;2#1;2;100;0;0@??",
+			want: [][]color.NRGBA{
+				{red, none, none},
+				{none, none, none},
+			},
+		},
+		{
+			name: "raster size is clipped to the sixels drawn",
+			data: "\"1;1;4096;4096#1;2;100;0;0@",
+			want: [][]color.NRGBA{
+				{red}, {none}, {none}, {none}, {none}, {none},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img, err := decodeSixel(test.data, 0)
+			if err != nil {
+				t.Fatalf("decodeSixel(%q) error = %v", test.data, err)
+			}
+			if diff := cmp.Diff(pixels(img), test.want); diff != "" {
+				t.Errorf("decodeSixel(%q) pixels diff (-got +want):\n%s", test.data, diff)
+			}
+		})
+	}
+}
+
+func TestDecodeSixelErrors(t *testing.T) {
+	tests := []struct {
+		data    string
+		maxSize int
+		want    string
+	}{
+		{data: "\"1;1;4096;4096", want: "image is empty"},
+		{data: "!2", want: "incomplete repeat"},
+		// 4x6 NRGBA pixels are 96 bytes.
+		{data: "!4~", maxSize: 95, want: "image too large"},
+		{data: "~-~", maxSize: 6*4*2 - 1, want: "image too large"},
+	}
+	for _, test := range tests {
+		_, err := decodeSixel(test.data, test.maxSize)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("decodeSixel(%q, %d) error = %v, want %q", test.data, test.maxSize, err, test.want)
+		}
+	}
+	if _, err := decodeSixel("!4~", 96); err != nil {
+		t.Errorf("decodeSixel(%q, 96) error = %v, want nil", "!4~", err)
+	}
+}
+
+func pixels(img image.Image) [][]color.NRGBA {
+	b := img.Bounds()
+	var rows [][]color.NRGBA
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var row []color.NRGBA
+		for x := b.Min.X; x < b.Max.X; x++ {
+			row = append(row, color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestSixelOutput(t *testing.T) {
+	s, err := NewScreen(WithImagePolicy(ImagePolicy{Lazy: true}))
+	if err != nil {
+		t.Fatalf("NewScreen() error = %v", err)
+	}
+	s.Write([]byte("before\x1bP0;1;0q#1;2;100;0;0@\x1b\\after\x1bP$qm\x1b\\\x1bPq!\x1b\\"))
+
+	html := s.AsHTML()
+	lines := strings.Split(html, "\n")
+	if len(lines) != 4 {
+		t.Fatalf("AsHTML() = %q, want 4 lines", html)
+	}
+	if lines[0] != "before" || lines[2] != "after" {
+		t.Errorf("AsHTML() = %q, want lines before and after the image", html)
+	}
+	if want := "*** Error parsing Sixel DCS ANSI escape sequence: incomplete repeat"; lines[3] != want {
+		t.Errorf("AsHTML() line 4 = %q, want %q", lines[3], want)
+	}
+
+	prefix := `<img alt="" src="data:image/png;base64,`
+	suffix := `" loading="lazy">`
+	if !strings.HasPrefix(lines[1], prefix) || !strings.HasSuffix(lines[1], suffix) {
+		t.Fatalf("AsHTML() line 2 = %q, want an image", lines[1])
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(lines[1], prefix), suffix))
+	if err != nil {
+		t.Fatalf("base64 decoding image: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("png.Decode(image) error = %v", err)
+	}
+	if diff := cmp.Diff(pixels(img), [][]color.NRGBA{{{255, 0, 0, 255}}}); diff != "" {
+		t.Errorf("image pixels diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestSixelErrorOwnLine(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() error = %v", err)
+	}
+	s.Write([]byte("progress\r\x1bPq!\x1b\\after"))
+	want := "*** Error parsing Sixel DCS ANSI escape sequence: incomplete repeat\nafter"
+	if diff := cmp.Diff(s.AsHTML(), want); diff != "" {
+		t.Errorf("AsHTML() diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestUnterminatedDCS(t *testing.T) {
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() error = %v", err)
+	}
+	// A stray ESC P, followed by more than a DCS can hold, split across
+	// writes, is output as text.
+	s.Write([]byte("before \x1bPrinted"))
+	line := strings.Repeat("x", 1023) + "\n"
+	lines := strings.Repeat(line, maxDCSLength/len(line)/2+1)
+	s.Write([]byte(lines))
+	s.Write([]byte(lines))
+	s.Write([]byte("after"))
+
+	text := s.AsPlainText()
+	if !strings.HasPrefix(text, "before Printedxxx") || !strings.HasSuffix(text, "xxx\nafter") {
+		t.Errorf("AsPlainText() = %q...%q, want the text after ESC", text[:min(len(text), 30)], text[max(0, len(text)-30):])
+	}
+	if got, want := strings.Count(text, "\n"), 2*(maxDCSLength/len(line)/2+1); got != want {
+		t.Errorf("AsPlainText() has %d lines, want %d", got, want)
+	}
+}
@@ -0,0 +1,65 @@
+package terminal
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"time"
+)
+
+// TimestampWriter stamps each line written to it with the time it arrived,
+// by writing a Buildkite timestamp APC ("ESC _ bk;t=<milliseconds> BEL") at
+// the start of the line, before passing it on. This gives logs captured
+// outside the Buildkite agent the same timestamps as agent logs, whether the
+// stamped stream is written to a Screen or saved as raw output.
+//
+// A line is stamped when its first byte is written. Lines that already have
+// a Buildkite timestamp keep it, since the last timestamp on a line wins.
+type TimestampWriter struct {
+	w io.Writer
+
+	// midLine is true if the last byte written wasn't a newline.
+	midLine bool
+
+	// Now returns the current time. If nil, time.Now is used.
+	Now func() time.Time
+}
+
+// NewTimestampWriter returns a TimestampWriter writing to w.
+func NewTimestampWriter(w io.Writer) *TimestampWriter {
+	return &TimestampWriter{w: w}
+}
+
+// Write writes p to the underlying writer, with a timestamp APC before each
+// line that starts in p. It returns the number of bytes of p written.
+func (tw *TimestampWriter) Write(p []byte) (int, error) {
+	now := time.Now
+	if tw.Now != nil {
+		now = tw.Now
+	}
+	stamp := []byte("\x1b_" + bkNamespace + ";t=" + strconv.FormatInt(now().UnixMilli(), 10) + "\x07")
+
+	written := 0
+	for len(p) > 0 {
+		if !tw.midLine {
+			if _, err := tw.w.Write(stamp); err != nil {
+				return written, err
+			}
+			tw.midLine = true
+		}
+
+		// Write up to and including the next newline.
+		end := len(p)
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			end = i + 1
+			tw.midLine = false
+		}
+		n, err := tw.w.Write(p[:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[end:]
+	}
+	return written, nil
+}
@@ -0,0 +1,56 @@
+package terminal
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTimestampWriter(t *testing.T) {
+	t.Parallel()
+
+	var sb strings.Builder
+	tw := NewTimestampWriter(&sb)
+	now := time.UnixMilli(1000)
+	tw.Now = func() time.Time { return now }
+
+	writes := []string{"one\ntw", "o\n", "\n", "three"}
+	for _, w := range writes {
+		n, err := tw.Write([]byte(w))
+		if err != nil {
+			t.Fatalf("tw.Write(%q) error = %v", w, err)
+		}
+		if n != len(w) {
+			t.Errorf("tw.Write(%q) = %d, want %d", w, n, len(w))
+		}
+		now = now.Add(time.Second)
+	}
+
+	want := "\x1b_bk;t=1000\x07one\n" +
+		"\x1b_bk;t=1000\x07two\n" +
+		"\x1b_bk;t=3000\x07\n" +
+		"\x1b_bk;t=4000\x07three"
+	if diff := cmp.Diff(sb.String(), want); diff != "" {
+		t.Errorf("stamped output diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestTimestampWriterToScreen(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewScreen()
+	if err != nil {
+		t.Fatalf("NewScreen() error = %v", err)
+	}
+	tw := NewTimestampWriter(s)
+	tw.Now = func() time.Time { return time.UnixMilli(1500) }
+	tw.Write([]byte("hello\n\x1b_bk;t=2000\x07world"))
+
+	want := `<time datetime="1970-01-01T00:00:01.5Z">1970-01-01T00:00:01.5Z</time>hello` + "\n" +
+		`<time datetime="1970-01-01T00:00:02Z">1970-01-01T00:00:02Z</time>world`
+	if diff := cmp.Diff(s.AsHTML(), want); diff != "" {
+		t.Errorf("AsHTML() diff (-got +want):\n%s", diff)
+	}
+}
@@ -333,10 +333,25 @@ var rendererTestCases = []struct {
 		want:  "abcghi",
 	},
 	{
-		name:  "correctly handles images that we decide not to render",
+		name:  "renders files that aren't inline as attachments on their own line",
 		input: "hi\x1b]1337;File=name=MS5naWY=;inline=0:AA==\ahello",
+		want:  "hi\n" + `<a class="term-attachment" href="data:image/gif;base64,AA==" download="1.gif">1.gif</a> <span class="term-attachment-size">(1 B)</span>` + "\nhello",
+	},
+	{
+		name:  "renders multipart files",
+		input: "\x1b]1337;MultipartFile=name=MS5naWY=;size=6;inline=1\a\x1b]1337;FilePart=AAAA\a\x1b]1337;FilePart=AAAA\a\x1b]1337;FileEnd\a",
+		want:  `<img alt="1.gif" src="data:image/gif;base64,AAAAAAAA">`,
+	},
+	{
+		name:  "ignores stray file parts",
+		input: "hi\x1b]1337;FilePart=AAAA\a\x1b]1337;FileEnd\ahello",
 		want:  "hihello",
 	},
+	{
+		name:  "keeps the aspect ratio of iTerm images with a width and height",
+		input: "\x1b]1337;File=name=MS5naWY=;width=10;height=5;inline=1:AA==\a",
+		want:  `<img alt="1.gif" src="data:image/gif;base64,AA==" width="10em" height="5em" style="object-fit: contain">`,
+	},
 	{
 		name:  "renders external images",
 		input: "\x1b]1338;url=http://foo.com/foobar.gif;alt=foo bar\a",
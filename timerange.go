@@ -0,0 +1,142 @@
+package terminal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeBound is one end of a time range (see WithTimeRange): either an
+// absolute time, or an offset from the first Buildkite timestamp written to
+// the screen. The zero TimeBound is unbounded.
+type TimeBound struct {
+	kind   int
+	time   time.Time
+	offset time.Duration
+}
+
+const (
+	timeBoundNone = iota
+	timeBoundAbsolute
+	timeBoundRelative
+)
+
+// AbsoluteTime returns a TimeBound at t.
+func AbsoluteTime(t time.Time) TimeBound {
+	return TimeBound{kind: timeBoundAbsolute, time: t}
+}
+
+// RelativeTime returns a TimeBound at d after the first Buildkite timestamp.
+func RelativeTime(d time.Duration) TimeBound {
+	return TimeBound{kind: timeBoundRelative, offset: d}
+}
+
+// ParseTimeBound parses a TimeBound. Absolute times are in RFC 3339 format
+// (e.g. 2024-01-02T03:04:05Z, with optional fractional seconds). Relative
+// times are either Go durations (e.g. 1m30s) or hh:mm:ss with optional
+// fractional seconds (e.g. 00:01:30.5), as shown by WithRelativeTimestamps,
+// and may be prefixed with +. Relative times can't be negative.
+func ParseTimeBound(s string) (TimeBound, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return AbsoluteTime(t), nil
+	}
+
+	rel := strings.TrimPrefix(s, "+")
+	if d, err := time.ParseDuration(rel); err == nil {
+		if d < 0 {
+			return TimeBound{}, fmt.Errorf("invalid time %q: relative times can't be negative", s)
+		}
+		return RelativeTime(d), nil
+	}
+	if h, ms, ok := strings.Cut(rel, ":"); ok {
+		m, sec, ok := strings.Cut(ms, ":")
+		hours, err1 := strconv.ParseUint(h, 10, 32)
+		mins, err2 := strconv.ParseUint(m, 10, 32)
+		secs, err3 := strconv.ParseFloat(sec, 64)
+		if ok && err1 == nil && err2 == nil && err3 == nil && mins < 60 && secs >= 0 && secs < 60 {
+			d := time.Duration(hours)*time.Hour + time.Duration(mins)*time.Minute +
+				time.Duration(secs*float64(time.Second)).Round(time.Millisecond)
+			return RelativeTime(d), nil
+		}
+	}
+	return TimeBound{}, fmt.Errorf("invalid time %q: want an RFC 3339 time, a duration, or hh:mm:ss", s)
+}
+
+// WithTimeRange limits output to lines with Buildkite timestamps within the
+// range, inclusive. Lines without a timestamp have the same timestamp as the
+// line before them; lines before the first timestamp are only included if
+// since is unbounded. Either bound can be the zero TimeBound, for no bound.
+//
+// Only output is affected: the lines outside the range are still processed,
+// and FlushChanges and Sections still report them. A log group whose header
+// is outside the range, but some of whose lines are inside it, is still
+// rendered with the header as its summary.
+func WithTimeRange(since, until TimeBound) ScreenOption {
+	return func(s *Screen) error {
+		for _, b := range []TimeBound{since, until} {
+			if b.kind == timeBoundRelative && b.offset < 0 {
+				return fmt.Errorf("relative time %v is negative", b.offset)
+			}
+		}
+		if since.kind == until.kind && since.kind != timeBoundNone {
+			if (since.kind == timeBoundAbsolute && until.time.Before(since.time)) ||
+				(since.kind == timeBoundRelative && until.offset < since.offset) {
+				return fmt.Errorf("time range ends before it starts")
+			}
+		}
+		s.since, s.until = since, until
+		return nil
+	}
+}
+
+// timeRangeState carries the timestamp that untimestamped lines inherit from
+// one line to the next.
+type timeRangeState struct {
+	last    int64
+	hasLast bool
+}
+
+// timeRange reports whether output is limited to a time range.
+func (s *Screen) timeRange() bool {
+	return s.since.kind != timeBoundNone || s.until.kind != timeBoundNone
+}
+
+// Time returns the bound as an absolute time, given the time of the first
+// Buildkite timestamp. ok is false for the zero (unbounded) TimeBound.
+func (b TimeBound) Time(first time.Time) (t time.Time, ok bool) {
+	switch b.kind {
+	case timeBoundAbsolute:
+		return b.time, true
+	case timeBoundRelative:
+		return first.Add(b.offset), true
+	}
+	return time.Time{}, false
+}
+
+// millis returns the bound in milliseconds since the epoch.
+func (b TimeBound) millis(first int64) int64 {
+	if b.kind == timeBoundAbsolute {
+		return b.time.UnixMilli()
+	}
+	return first + b.offset.Milliseconds()
+}
+
+// inTimeRange updates the state for the next line, and reports whether the
+// line should be output.
+func (s *Screen) inTimeRange(st *timeRangeState, parts []screenLine) bool {
+	if t, ok := lineTimestamp(parts); ok {
+		st.last, st.hasLast = t, true
+	}
+	if !st.hasLast {
+		return s.since.kind == timeBoundNone
+	}
+	first := s.parser.firstTimestamp
+	if s.since.kind != timeBoundNone && st.last < s.since.millis(first) {
+		return false
+	}
+	if s.until.kind != timeBoundNone && st.last > s.until.millis(first) {
+		return false
+	}
+	return true
+}
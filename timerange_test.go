@@ -0,0 +1,197 @@
+package terminal
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseTimeBound(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		input string
+		want  TimeBound
+	}{
+		{"2024-01-02T03:04:05Z", AbsoluteTime(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))},
+		{"2024-01-02T14:04:05.5+11:00", AbsoluteTime(time.Date(2024, 1, 2, 3, 4, 5, 500_000_000, time.UTC))},
+		{"90s", RelativeTime(90 * time.Second)},
+		{"+1m30s", RelativeTime(90 * time.Second)},
+		{"+00:01:23.456", RelativeTime(83456 * time.Millisecond)},
+		{"1:00:00", RelativeTime(time.Hour)},
+	}
+	for _, test := range tests {
+		got, err := ParseTimeBound(test.input)
+		if err != nil {
+			t.Errorf("ParseTimeBound(%q) error = %v", test.input, err)
+			continue
+		}
+		if got.kind != test.want.kind || !got.time.Equal(test.want.time) || got.offset != test.want.offset {
+			t.Errorf("ParseTimeBound(%q) = %+v, want %+v", test.input, got, test.want)
+		}
+	}
+
+	for _, input := range []string{"", "yesterday", "00:61:00", "1:2", "-5s", "+-5s"} {
+		if _, err := ParseTimeBound(input); err == nil {
+			t.Errorf("ParseTimeBound(%q) error = nil, want error", input)
+		}
+	}
+}
+
+func TestTimeRange(t *testing.T) {
+	t.Parallel()
+
+	input := strings.Join([]string{
+		"preamble",
+		"\x1b_bk;t=10000\x07one",
+		"continued",
+		"\x1b_bk;t=20000\x07two",
+		"\x1b_bk;t=30000\x07three",
+		"\x1b_bk;t=40000\x07four",
+	}, "\n")
+
+	tests := []struct {
+		name         string
+		since, until TimeBound
+		want         string
+	}{
+		{
+			name: "unbounded",
+			want: "preamble\none\ncontinued\ntwo\nthree\nfour",
+		},
+		{
+			name:  "absolute",
+			since: AbsoluteTime(time.UnixMilli(20000)),
+			until: AbsoluteTime(time.UnixMilli(30000)),
+			want:  "two\nthree",
+		},
+		{
+			name:  "relative",
+			since: RelativeTime(5 * time.Second),
+			until: RelativeTime(15 * time.Second),
+			want:  "two",
+		},
+		{
+			name:  "untimestamped lines inherit",
+			until: RelativeTime(5 * time.Second),
+			want:  "preamble\none\ncontinued",
+		},
+		{
+			name:  "since only",
+			since: AbsoluteTime(time.UnixMilli(35000)),
+			want:  "four",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			for _, maxLines := range []int{0, 1, 3} {
+				s, err := NewScreen(WithTimeRange(test.since, test.until), WithMaxSize(0, maxLines))
+				if err != nil {
+					t.Fatalf("NewScreen(WithTimeRange(...), WithMaxSize(0, %d)) error = %v", maxLines, err)
+				}
+				var plain strings.Builder
+				s.ScrollOutPlainFunc = func(line string) { plain.WriteString(line) }
+				s.Timestamps = false
+				s.Write([]byte(input))
+				plain.WriteString(s.AsPlainText())
+				if diff := cmp.Diff(strings.TrimSuffix(plain.String(), "\n"), test.want); diff != "" {
+					t.Errorf("with max lines %d, plain text diff (-got +want):\n%s", maxLines, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestTimeRangeBackwards(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewScreen(WithTimeRange(RelativeTime(time.Minute), RelativeTime(time.Second))); err == nil {
+		t.Errorf("NewScreen(WithTimeRange(+1m, +1s)) error = nil, want error")
+	}
+}
+
+func TestTimeRangeNegative(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewScreen(WithTimeRange(RelativeTime(-time.Second), TimeBound{})); err == nil {
+		t.Errorf("NewScreen(WithTimeRange(-1s, unbounded)) error = nil, want error")
+	}
+}
+
+func TestTimeRangeGroups(t *testing.T) {
+	t.Parallel()
+
+	input := strings.Join([]string{
+		"\x1b_bk;t=10000\x07--- one",
+		"\x1b_bk;t=20000\x07a",
+		"\x1b_bk;t=30000\x07b",
+		"\x1b_bk;t=40000\x07+++ two",
+		"\x1b_bk;t=50000\x07c",
+		"\x1b_bk;t=60000\x07--- three",
+	}, "\n")
+
+	tests := []struct {
+		name         string
+		since, until TimeBound
+		want         string
+	}{
+		{
+			name:  "header before the range",
+			since: RelativeTime(20 * time.Second),
+			until: RelativeTime(40 * time.Second),
+			want: strings.Join([]string{
+				`<details class="term-group" data-group-start="1970-01-01T00:00:10Z"><summary>--- one</summary>`,
+				`b`,
+				`<time class="term-group-duration" datetime="PT30S">30s</time></details><details class="term-group" open data-group-start="1970-01-01T00:00:40Z"><summary>+++ two</summary>`,
+				`c`,
+				`<time class="term-group-duration" datetime="PT20S">20s</time></details>`,
+			}, "\n"),
+		},
+		{
+			name:  "header after the range",
+			until: RelativeTime(25 * time.Second),
+			want: strings.Join([]string{
+				`<details class="term-group" data-group-start="1970-01-01T00:00:10Z"><summary>--- one</summary>`,
+				`a`,
+				`b`,
+				`<time class="term-group-duration" datetime="PT30S">30s</time></details>`,
+			}, "\n"),
+		},
+		{
+			name:  "whole groups outside the range",
+			since: RelativeTime(35 * time.Second),
+			until: RelativeTime(45 * time.Second),
+			want: strings.Join([]string{
+				`<details class="term-group" open data-group-start="1970-01-01T00:00:40Z"><summary>+++ two</summary>`,
+				`c`,
+				`<time class="term-group-duration" datetime="PT20S">20s</time></details>`,
+			}, "\n"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			for _, maxLines := range []int{0, 1, 3} {
+				s, err := NewScreen(WithGroups(true), WithTimeRange(test.since, test.until), WithMaxSize(0, maxLines))
+				if err != nil {
+					t.Fatalf("NewScreen() error = %v", err)
+				}
+				var html strings.Builder
+				s.ScrollOutFunc = func(line string) { html.WriteString(line) }
+				s.Timestamps = false
+				s.Write([]byte(input))
+				html.WriteString(s.AsHTMLWithTimestamps(false))
+				if diff := cmp.Diff(html.String(), test.want); diff != "" {
+					t.Errorf("with max lines %d, HTML diff (-got +want):\n%s", maxLines, diff)
+				}
+			}
+		})
+	}
+}
@@ -1,31 +1,211 @@
 package terminal
 
 import (
+	"fmt"
 	"net/url"
 	"slices"
+	"strings"
 )
 
 const unsafeURLSubstitution = "#"
 
+// Schemes that are never allowed, because they run script in the page.
+var scriptSchemes = []string{"javascript", "vbscript"}
+
+// Schemes denied by the default URL policy. data: URLs can contain arbitrary
+// documents, including HTML with script.
+var defaultDeniedSchemes = append([]string{"data"}, scriptSchemes...)
+
+// Schemes of URLs that browsers always find a host in (the WHATWG URL
+// standard's "special" schemes, apart from file).
+var specialSchemes = []string{"ftp", "http", "https", "ws", "wss"}
+
+// URLPolicy controls which URLs can be used in links and images (see
+// WithURLPolicy). URLs that aren't allowed are replaced with "#" in links, and
+// images with them aren't rendered.
+//
+// The zero URLPolicy allows any URL except javascript:, vbscript: and data:
+// URLs.
+type URLPolicy struct {
+	// AllowedSchemes, if not empty, lists the only schemes allowed in absolute
+	// URLs (e.g. "https", "mailto"). javascript: and vbscript: URLs are never
+	// allowed, but data: URLs are if listed.
+	AllowedSchemes []string
+
+	// AllowedHosts, if not empty, lists the only hosts allowed in URLs with a
+	// host. A host starting with "*." also allows its subdomains, e.g.
+	// "*.example.com" allows example.com and www.example.com.
+	AllowedHosts []string
+
+	// DenyRelative denies URLs without a scheme.
+	DenyRelative bool
+
+	// BaseURL, if not nil, is the absolute URL that URLs without a scheme are
+	// resolved against. The resolved URLs are then checked like any other.
+	BaseURL *url.URL
+}
+
+// WithURLPolicy sets the policy for URLs in links and images. Schemes and hosts
+// are matched case-insensitively.
+func WithURLPolicy(p URLPolicy) ScreenOption {
+	return func(s *Screen) error {
+		var np URLPolicy
+		for _, scheme := range p.AllowedSchemes {
+			scheme = strings.ToLower(strings.TrimSuffix(scheme, ":"))
+			if !isURLScheme(scheme) {
+				return fmt.Errorf("invalid URL scheme %q", scheme)
+			}
+			np.AllowedSchemes = append(np.AllowedSchemes, scheme)
+		}
+		for _, host := range p.AllowedHosts {
+			if strings.TrimPrefix(host, "*.") == "" {
+				return fmt.Errorf("invalid URL host %q", host)
+			}
+			np.AllowedHosts = append(np.AllowedHosts, strings.ToLower(host))
+		}
+		if p.DenyRelative && p.BaseURL != nil {
+			return fmt.Errorf("URL policy can't both deny and resolve relative URLs")
+		}
+		if p.BaseURL != nil && !p.BaseURL.IsAbs() {
+			return fmt.Errorf("base URL %q is not absolute", p.BaseURL)
+		}
+		np.DenyRelative, np.BaseURL = p.DenyRelative, p.BaseURL
+		s.urlPolicy = np
+		return nil
+	}
+}
+
+// isURLScheme reports whether s is a valid URL scheme (RFC 3986 section 3.1).
+func isURLScheme(s string) bool {
+	if s == "" || !('a' <= s[0] && s[0] <= 'z') {
+		return false
+	}
+	for _, c := range s {
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '+' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// sanitizeURL checks s against the default URL policy.
 func sanitizeURL(s string) string {
-	url, err := url.Parse(s)
+	return URLPolicy{}.sanitize(s)
+}
+
+// sanitize returns s, normalised, if the policy allows it, or
+// unsafeURLSubstitution if not.
+func (p URLPolicy) sanitize(s string) string {
+	// Browsers ignore leading and trailing spaces and control characters, and
+	// tabs and newlines anywhere, so "\tjava\nscript:" is a javascript: URL.
+	s = strings.TrimFunc(s, func(r rune) bool { return r <= ' ' })
+	s = strings.NewReplacer("\t", "", "\n", "", "\r", "").Replace(s)
+
+	// url.Parse lowercases the scheme, and rejects other control characters.
+	u, err := url.Parse(s)
 	if err != nil {
 		return unsafeURLSubstitution
 	}
 
-	// relative URLs (no scheme) are permitted
-	if url.Scheme == "" {
-		return url.String()
+	// relative URLs (no scheme) are permitted, unless the policy says otherwise
+	if u.Scheme == "" {
+		switch {
+		case p.DenyRelative:
+			return unsafeURLSubstitution
+		case p.BaseURL != nil:
+			u = p.BaseURL.ResolveReference(u)
+		}
 	}
 
-	// deny-list known-XSS-dangerous URL schemes for <a href=""> etc.
-	// An allow-list would be preferable, but we don't know what URL schemes are being legitimately
-	// used in the wild, so that would be a breaking change, and likely require configurability.
-	disallowedSchemes := []string{"javascript"}
-	if slices.Contains(disallowedSchemes, url.Scheme) {
+	if u.Scheme != "" {
+		// deny-list known-XSS-dangerous URL schemes for <a href=""> etc.
+		if slices.Contains(scriptSchemes, u.Scheme) {
+			return unsafeURLSubstitution
+		}
+		if len(p.AllowedSchemes) > 0 {
+			if !slices.Contains(p.AllowedSchemes, u.Scheme) {
+				return unsafeURLSubstitution
+			}
+		} else if slices.Contains(defaultDeniedSchemes, u.Scheme) {
+			return unsafeURLSubstitution
+		}
+	}
+
+	// Network-path references ("//example.com/") have a host but no scheme.
+	if len(p.AllowedHosts) > 0 && u.Host != "" && !hostMatches(p.AllowedHosts, u.Hostname()) {
 		return unsafeURLSubstitution
 	}
 
-	// default allow
-	return url.String()
+	// Browsers find a host in URLs with special schemes even without the
+	// "//", so "https:evil.com" and "https:/evil.com" both go to evil.com
+	// (when the page's scheme differs), but url.Parse finds no host.
+	if len(p.AllowedHosts) > 0 && slices.Contains(specialSchemes, u.Scheme) && (u.Opaque != "" || u.Host == "") {
+		return unsafeURLSubstitution
+	}
+
+	return u.String()
+}
+
+// hostMatches reports whether host is one of hosts, where a host starting
+// with "*." also matches its subdomains. hosts must be lowercase.
+func hostMatches(hosts []string, host string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range hosts {
+		if domain, ok := strings.CutPrefix(allowed, "*."); ok {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// LinkAttributes are extra attributes for a link (see WithLinkAttributes).
+// Empty attributes are omitted.
+type LinkAttributes struct {
+	// Target is the browsing context to open the link in, e.g. "_blank".
+	Target string
+
+	// Rel is the relationship to the linked URL, e.g. "noopener noreferrer".
+	Rel string
+
+	// ReferrerPolicy is the referrer sent when following the link, e.g.
+	// "no-referrer".
+	ReferrerPolicy string
+}
+
+// WithLinkAttributes sets a policy for the attributes of links, from OSC 8
+// and 1339 sequences and the linkifier (see WithLinkifier). attrs is called
+// with the URL of each link, after it has been checked against the URL policy
+// (see WithURLPolicy).
+func WithLinkAttributes(attrs func(url string) LinkAttributes) ScreenOption {
+	return func(s *Screen) error {
+		s.linkAttrs = attrs
+		return nil
+	}
+}
+
+// ExternalLinkAttributes returns a link attribute policy (see
+// WithLinkAttributes) that opens links to hosts other than internalHosts in a
+// new tab, with rel="noopener noreferrer nofollow" and no referrer. A host
+// starting with "*." also matches its subdomains. Links without a host, such
+// as relative URLs, are internal.
+func ExternalLinkAttributes(internalHosts ...string) func(url string) LinkAttributes {
+	hosts := make([]string, len(internalHosts))
+	for i, h := range internalHosts {
+		hosts[i] = strings.ToLower(h)
+	}
+	return func(s string) LinkAttributes {
+		u, err := url.Parse(s)
+		if err != nil || u.Host == "" || hostMatches(hosts, u.Hostname()) {
+			return LinkAttributes{}
+		}
+		return LinkAttributes{
+			Target:         "_blank",
+			Rel:            "noopener noreferrer nofollow",
+			ReferrerPolicy: "no-referrer",
+		}
+	}
 }
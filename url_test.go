@@ -2,7 +2,11 @@ package terminal
 
 import (
 	"fmt"
+	"net/url"
+	"strings"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestSanitizeURL(t *testing.T) {
@@ -22,6 +26,17 @@ func TestSanitizeURL(t *testing.T) {
 
 		// known-dangerous schemes
 		{input: "javascript:alert(1)", want: "#"},
+		{input: "vbscript:msgbox(1)", want: "#"},
+		{input: "data:text/html,<script>alert(1)</script>", want: "#"},
+
+		// obfuscated schemes
+		{input: "JavaScript:alert(1)", want: "#"},
+		{input: " javascript:alert(1)", want: "#"},
+		{input: "\x01javascript:alert(1)", want: "#"},
+		{input: "java\tscript:alert(1)", want: "#"},
+		{input: "java\nscript:alert(1)", want: "#"},
+		{input: "java\x00script:alert(1)", want: "#"},
+		{input: " https://example.org/ ", want: "https://example.org/"},
 
 		// not-specifically-allow-listed schemes
 		{input: "ftp://example.org/", want: "ftp://example.org/"},
@@ -38,3 +53,186 @@ func TestSanitizeURL(t *testing.T) {
 		})
 	}
 }
+
+func TestURLPolicy(t *testing.T) {
+	base, err := url.Parse("https://buildkite.com/org/pipeline/")
+	if err != nil {
+		t.Fatalf("url.Parse() error = %v", err)
+	}
+
+	testCases := []struct {
+		name   string
+		policy URLPolicy
+		input  string
+		want   string
+	}{
+		{
+			name:   "allowed scheme",
+			policy: URLPolicy{AllowedSchemes: []string{"https", "MAILTO:"}},
+			input:  "mailto:hello@example.org",
+			want:   "mailto:hello@example.org",
+		},
+		{
+			name:   "unlisted scheme",
+			policy: URLPolicy{AllowedSchemes: []string{"https"}},
+			input:  "http://example.org/",
+			want:   "#",
+		},
+		{
+			name:   "listed data scheme",
+			policy: URLPolicy{AllowedSchemes: []string{"data"}},
+			input:  "data:image/png;base64,AA==",
+			want:   "data:image/png;base64,AA==",
+		},
+		{
+			name:   "script schemes can't be allowed",
+			policy: URLPolicy{AllowedSchemes: []string{"javascript"}},
+			input:  "javascript:alert(1)",
+			want:   "#",
+		},
+		{
+			name:   "allowed host",
+			policy: URLPolicy{AllowedHosts: []string{"example.org"}},
+			input:  "https://EXAMPLE.org:8080/",
+			want:   "https://EXAMPLE.org:8080/",
+		},
+		{
+			name:   "unlisted host",
+			policy: URLPolicy{AllowedHosts: []string{"example.org"}},
+			input:  "https://www.example.org/",
+			want:   "#",
+		},
+		{
+			name:   "allowed subdomain",
+			policy: URLPolicy{AllowedHosts: []string{"*.example.org"}},
+			input:  "https://www.example.org/",
+			want:   "https://www.example.org/",
+		},
+		{
+			name:   "opaque URL with a special scheme",
+			policy: URLPolicy{AllowedHosts: []string{"example.org"}},
+			input:  "https:evil.example.com",
+			want:   "#",
+		},
+		{
+			name:   "special scheme without a host",
+			policy: URLPolicy{AllowedHosts: []string{"example.org"}},
+			input:  "http:/evil.example.com/",
+			want:   "#",
+		},
+		{
+			name:   "opaque URL with another scheme",
+			policy: URLPolicy{AllowedHosts: []string{"example.org"}},
+			input:  "mailto:hello@example.com",
+			want:   "mailto:hello@example.com",
+		},
+		{
+			name:   "network-path reference to unlisted host",
+			policy: URLPolicy{AllowedHosts: []string{"example.org"}},
+			input:  "//evil.example.com/",
+			want:   "#",
+		},
+		{
+			name:   "hosts don't apply to URLs without one",
+			policy: URLPolicy{AllowedHosts: []string{"example.org"}},
+			input:  "tel:0123456789",
+			want:   "tel:0123456789",
+		},
+		{
+			name:   "denied relative URL",
+			policy: URLPolicy{DenyRelative: true},
+			input:  "hello.txt",
+			want:   "#",
+		},
+		{
+			name:   "resolved relative URL",
+			policy: URLPolicy{BaseURL: base},
+			input:  "builds/1",
+			want:   "https://buildkite.com/org/pipeline/builds/1",
+		},
+		{
+			name:   "resolved relative URL checked against hosts",
+			policy: URLPolicy{BaseURL: base, AllowedHosts: []string{"example.org"}},
+			input:  "/builds/1",
+			want:   "#",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewScreen(WithURLPolicy(tc.policy))
+			if err != nil {
+				t.Fatalf("NewScreen(WithURLPolicy(%+v)) error = %v", tc.policy, err)
+			}
+			if got := s.urlPolicy.sanitize(tc.input); got != tc.want {
+				t.Errorf("sanitize(%q) = %q, want %q", tc.input, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestURLPolicyErrors(t *testing.T) {
+	relative := &url.URL{Path: "relative"}
+	for _, p := range []URLPolicy{
+		{AllowedSchemes: []string{"ht tp"}},
+		{AllowedHosts: []string{"*."}},
+		{DenyRelative: true, BaseURL: &url.URL{Scheme: "https", Host: "example.org"}},
+		{BaseURL: relative},
+	} {
+		if _, err := NewScreen(WithURLPolicy(p)); err == nil {
+			t.Errorf("NewScreen(WithURLPolicy(%+v)) error = nil, want error", p)
+		}
+	}
+}
+
+func TestURLPolicyOutput(t *testing.T) {
+	input := strings.Join([]string{
+		"\x1b]8;;https://evil.example.com/\x07link\x1b]8;;\x07",
+		"\x1b]1339;url=https://example.org/;content=ok\x07",
+		"\x1b]1338;url=https://evil.example.com/a.png\x07",
+		"\x1b]1338;url=https://example.org/a.png\x07",
+	}, "\n")
+	want := strings.Join([]string{
+		`<a href="#">link</a>`,
+		`<a href="https://example.org/">ok</a>`,
+		`&nbsp;`,
+		`&nbsp;`,
+		`<img alt="https://example.org/a.png" src="https://example.org/a.png">`,
+	}, "\n")
+
+	s, err := NewScreen(WithURLPolicy(URLPolicy{AllowedHosts: []string{"example.org"}}))
+	if err != nil {
+		t.Fatalf("NewScreen(WithURLPolicy(...)) error = %v", err)
+	}
+	s.Write([]byte(input))
+	if diff := cmp.Diff(s.AsHTML(), want); diff != "" {
+		t.Errorf("AsHTML() diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestLinkAttributes(t *testing.T) {
+	input := strings.Join([]string{
+		"\x1b]8;;https://evil.example.com/\x07osc8\x1b]8;;\x07",
+		"\x1b]1339;url=https://www.buildkite.com/;content=internal\x07",
+		"\x1b]1339;url=/relative;content=relative\x07",
+		"see https://example.org/",
+	}, "\n")
+	want := strings.Join([]string{
+		`<a href="https://evil.example.com/" target="_blank" rel="noopener noreferrer nofollow" referrerpolicy="no-referrer">osc8</a>`,
+		`<a href="https://www.buildkite.com/">internal</a>`,
+		`<a href="/relative">relative</a>`,
+		`see <a href="https://example.org/" target="_blank" rel="noopener noreferrer nofollow" referrerpolicy="no-referrer">https:&#47;&#47;example.org&#47;</a>`,
+	}, "\n")
+
+	s, err := NewScreen(
+		WithLinkAttributes(ExternalLinkAttributes("*.Buildkite.com")),
+		WithLinkifier(URLLinks()),
+	)
+	if err != nil {
+		t.Fatalf("NewScreen(WithLinkAttributes(...), WithLinkifier(...)) error = %v", err)
+	}
+	s.Write([]byte(input))
+	if diff := cmp.Diff(s.AsHTML(), want); diff != "" {
+		t.Errorf("AsHTML() diff (-got +want):\n%s", diff)
+	}
+}